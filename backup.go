@@ -0,0 +1,308 @@
+package gig
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+)
+
+// Archive written by Backup has live keys and values of store as they was at the moment of backup
+// Archive starts with header of HEADER_SIZE bytes:
+// magic(4) archive version(1) format version of store(1) reserved(10) key check(16)
+// Key check is empty if store has no key, see openCipher. Header followed by records:
+// cmd(1) keySize(uvarint) key [expire(8)] valSize(uvarint) value valCRC(4)
+// Cmd has flags of key record, values stored as is, so sealed values stay sealed
+// Archive ended by commit record with count of records and checksum of archive before it:
+// cmd(1) count(8) CRC(4)
+
+// ARCHIVE_VERSION - version of archive written by Backup
+const ARCHIVE_VERSION = 1
+
+// archiveChunk - max part of key or value read from archive at once,
+// so memory of broken size is not allocated before bytes are read
+const archiveChunk = 1024 * 1024
+
+var archiveMagic = []byte("GIGA")
+
+// Backup write archive of live keys and values of store to w
+// Archive is written from snapshot, so writes are not stopped, see Snapshot
+func Backup(file string, w io.Writer) error {
+	v, err := Snapshot(file)
+	if err != nil {
+		return err
+	}
+	defer v.Release()
+	return v.backup(w)
+}
+
+// backup write archive of keys and values of view
+func (v *View) backup(w io.Writer) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.released {
+		return ErrDbNotOpen
+	}
+	header := make([]byte, HEADER_SIZE)
+	copy(header, archiveMagic)
+	header[len(archiveMagic)] = ARCHIVE_VERSION
+	header[len(archiveMagic)+1] = v.version
+	if v.version > 0 {
+		check, err := readKeyCheck(v.fv)
+		if err != nil {
+			return err
+		}
+		copy(header[keyCheckSeek:], check)
+	}
+	bw := bufio.NewWriter(w)
+	h := crc32.New(crcTable)
+	aw := io.MultiWriter(bw, h)
+	if _, err := aw.Write(header); err != nil {
+		return err
+	}
+	var count uint64
+	var err error
+	v.index.ascend(0, func(n *node) bool {
+		if n.cmd.expired(v.at) {
+			return true
+		}
+		var val []byte
+		if val, err = readStored(v.fv, v.version, n.cmd); err != nil {
+			return false
+		}
+		rec := n.cmd.record(FORMAT_VERSION, n.key)
+		b := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(n.key)+8)
+		b = append(b, rec.cmd)
+		b = binary.AppendUvarint(b, uint64(len(n.key)))
+		b = append(b, n.key...)
+		if rec.cmd&flagExpire != 0 {
+			b = binary.BigEndian.AppendUint64(b, uint64(rec.expire))
+		}
+		b = binary.AppendUvarint(b, uint64(len(val)))
+		if _, err = aw.Write(b); err == nil {
+			_, err = aw.Write(val)
+		}
+		if err == nil {
+			_, err = aw.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(val, crcTable)))
+		}
+		count++
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = aw.Write(binary.BigEndian.AppendUint64([]byte{cmdCommit}, count)); err != nil {
+		return err
+	}
+	if _, err = bw.Write(binary.BigEndian.AppendUint32(nil, h.Sum32())); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// archiveReader read archive and count checksum of read bytes
+type archiveReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+// ReadByte read byte of archive
+func (ar *archiveReader) ReadByte() (byte, error) {
+	b, err := ar.r.ReadByte()
+	if err == nil {
+		ar.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+// read return next n bytes of archive, they are read by parts of archiveChunk
+func (ar *archiveReader) read(n uint64) ([]byte, error) {
+	if n > 1<<32 {
+		return nil, ErrCorrupted
+	}
+	b := make([]byte, 0, min(n, archiveChunk))
+	for uint64(len(b)) < n {
+		start := len(b)
+		part := int(min(n-uint64(start), archiveChunk))
+		b = slices.Grow(b, part)[:start+part]
+		if _, err := io.ReadFull(ar.r, b[start:]); err != nil {
+			return nil, err
+		}
+	}
+	ar.h.Write(b)
+	return b, nil
+}
+
+// Restore write store from archive written by Backup to new files and replace files of store by them
+// Store must not be opened by this or other process, return ErrDbOpened or ErrLocked
+// Archive checked while reading, damaged or truncated archive return ErrCorrupted
+// and files of store are not changed
+func Restore(r io.Reader, file string) (err error) {
+	// store locked under mutex, so it is not opened by this process while archive is read
+	mutex.Lock()
+	fl, fr, err := lockRestore(file)
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	defer unlockStore(fl)
+	defer unlockStore(fr)
+	if err = recoverCompact(file); err != nil {
+		return err
+	}
+	// new generation, so old checkpoint will not be used
+	var generation uint64
+	if fk, err := os.Open(file + KEY_FILE_EXT); err == nil {
+		if version, _, err := readHeader(fk, keyMagic); err == nil && version > 0 {
+			if generation, err = readGeneration(fk); err == nil {
+				generation++
+			}
+		}
+		fk.Close()
+	}
+
+	s := &store{file: file}
+	c := &compaction{version: FORMAT_VERSION}
+	defer func() {
+		if err != nil {
+			s.removeCompacted(c)
+		}
+	}()
+	opts := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	// file with values created first, see recoverCompact
+	if c.fv, err = os.OpenFile(file+VAL_FILE_EXT+COMPACT_FILE_EXT, opts, FILE_MODE); err != nil {
+		return err
+	}
+	if c.fk, err = os.OpenFile(file+KEY_FILE_EXT+COMPACT_FILE_EXT, opts, FILE_MODE); err != nil {
+		return err
+	}
+	if err = writeHeader(c.fv, valMagic, c.version, generation); err != nil {
+		return err
+	}
+	if err = writeHeader(c.fk, keyMagic, c.version, generation); err != nil {
+		return err
+	}
+	if err = c.restore(r); err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrCorrupted
+	}
+	if err != nil {
+		return err
+	}
+	if err = c.fv.Sync(); err != nil {
+		return err
+	}
+	if err = c.fk.Sync(); err != nil {
+		return err
+	}
+	c.fv.Close()
+	c.fk.Close()
+	os.Remove(file + CHECKPOINT_FILE_EXT)
+	// rename of values is the point of no return, see recoverCompact
+	if err = os.Rename(file+VAL_FILE_EXT+COMPACT_FILE_EXT, file+VAL_FILE_EXT); err != nil {
+		return err
+	}
+	// new keys file is not removed, it will be renamed on open
+	c.fk, c.fv = nil, nil
+	if err = os.Rename(file+KEY_FILE_EXT+COMPACT_FILE_EXT, file+KEY_FILE_EXT); err != nil {
+		return err
+	}
+	return syncDir(file)
+}
+
+// lockRestore lock store for Restore, it must be called under mutex
+// Files are replaced, so store must not be opened by writer and readers
+func lockRestore(file string) (fl, fr *os.File, err error) {
+	if _, ok := stores[file]; ok {
+		return nil, nil, ErrDbOpened
+	}
+	if exists, err := checkAndCreate(file); exists && err != nil {
+		return nil, nil, err
+	}
+	if fl, err = lockStore(file+LOCK_FILE_EXT, false, 0); err != nil {
+		return nil, nil, err
+	}
+	if fr, err = lockStore(file+READ_LOCK_FILE_EXT, false, 0); err != nil {
+		unlockStore(fl)
+		return nil, nil, err
+	}
+	return fl, fr, nil
+}
+
+// restore read header and records of archive and write them to new files
+func (c *compaction) restore(r io.Reader) error {
+	ar := &archiveReader{r: bufio.NewReader(r), h: crc32.New(crcTable)}
+	header, err := ar.read(HEADER_SIZE)
+	if err != nil {
+		return err
+	}
+	if string(header[:len(archiveMagic)]) != string(archiveMagic) ||
+		header[len(archiveMagic)] != ARCHIVE_VERSION || header[len(archiveMagic)+1] > FORMAT_VERSION {
+		return ErrUnknownFormat
+	}
+	if _, err = c.fv.WriteAt(header[keyCheckSeek:], keyCheckSeek); err != nil {
+		return err
+	}
+	var count uint64
+	for {
+		t, err := ar.ReadByte()
+		if err != nil {
+			return err
+		}
+		if t&cmdMask == cmdCommit {
+			break
+		}
+		if t&cmdMask != cmdSet {
+			return ErrUnknownCommand
+		}
+		rec := keyRecord{cmd: t}
+		n, err := binary.ReadUvarint(ar)
+		if err != nil {
+			return err
+		}
+		if rec.key, err = ar.read(n); err != nil {
+			return err
+		}
+		if t&flagExpire != 0 {
+			b, err := ar.read(8)
+			if err != nil {
+				return err
+			}
+			rec.expire = int64(binary.BigEndian.Uint64(b))
+		}
+		if n, err = binary.ReadUvarint(ar); err != nil {
+			return err
+		}
+		val, err := ar.read(n)
+		if err != nil {
+			return err
+		}
+		b, err := ar.read(4)
+		if err != nil {
+			return err
+		}
+		if crc32.Checksum(val, crcTable) != binary.BigEndian.Uint32(b) {
+			return ErrCorrupted
+		}
+		old := &Cmd{Expire: rec.expire, Codec: rec.codec(), Sealed: t&flagSealed != 0}
+		if _, err = c.write(rec.key, val, old); err != nil {
+			return err
+		}
+		count++
+	}
+	b, err := ar.read(8)
+	if err != nil {
+		return err
+	}
+	sum := ar.h.Sum32()
+	crc := make([]byte, 4)
+	if _, err = io.ReadFull(ar.r, crc); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint64(b) != count || binary.BigEndian.Uint32(crc) != sum {
+		return ErrCorrupted
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBackup(t *testing.T) {
+	f := "tests/TestBackup.db"
+	dest := "tests/TestBackupRestored.db"
+	DeleteFile(f)
+	DeleteFile(dest)
+	defer CloseAll()
+	key := bytes.Repeat([]byte("k"), 32)
+	_, err := OpenWithOptions(f, &Options{Key: key, Compression: CODEC_LZ, CompressMinSize: 1})
+	ch(err, t)
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+		ch(Set(f, k, bytes.Repeat(k, i)), t)
+	}
+	ch(SetWithTTL(f, []byte("ttl"), []byte("ttl"), time.Hour), t)
+	ch(SetWithTTL(f, []byte("expired"), []byte("expired"), time.Millisecond), t)
+	Delete(f, []byte("000"))
+	time.Sleep(5 * time.Millisecond)
+
+	var buf bytes.Buffer
+	ch(Backup(f, &buf), t)
+	// writes after backup are not in archive
+	ch(Set(f, []byte("after"), []byte("after")), t)
+	archive := buf.Bytes()
+	if bytes.Contains(archive, []byte("099099")) {
+		t.Error("value not sealed")
+	}
+
+	// damaged and truncated archives
+	for _, b := range [][]byte{archive[:len(archive)-1], archive[:len(archive)/2], archive[:10],
+		append(append([]byte{}, archive[:100]...), append([]byte{archive[100] ^ 1}, archive[101:]...)...)} {
+		if err = Restore(bytes.NewReader(b), dest); err != ErrCorrupted {
+			t.Error("damaged archive restored", err)
+		}
+	}
+	// store is opened
+	if err = Restore(bytes.NewReader(archive), f); err != ErrDbOpened {
+		t.Error("restored opened store", err)
+	}
+
+	ch(Restore(bytes.NewReader(archive), dest), t)
+	if _, err = Open(dest); err != ErrWrongKey {
+		t.Error("opened without key", err)
+	}
+	_, err = OpenWithOptions(dest, &Options{Key: key})
+	ch(err, t)
+	if cnt, _ := Count(dest); cnt != 100 {
+		t.Error("wrong count", cnt)
+	}
+	for i := 1; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+		if v, err := Get(dest, k); err != nil || !bytes.Equal(v, bytes.Repeat(k, i)) {
+			t.Error("not equal", string(k), err)
+		}
+	}
+	if ttl, err := TTL(dest, []byte("ttl")); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Error("ttl not restored", ttl, err)
+	}
+	for _, k := range []string{"000", "expired", "after"} {
+		if has, _ := Has(dest, []byte(k)); has {
+			t.Error("key restored", k)
+		}
+	}
+
+	// restore replace files of closed store
+	ch(Close(dest), t)
+	buf.Reset()
+	ch(Close(f), t)
+	_, err = OpenWithOptions(f, &Options{Key: key})
+	ch(err, t)
+	ch(Backup(f, &buf), t)
+	ch(Restore(&buf, dest), t)
+	_, err = OpenWithOptions(dest, &Options{Key: key})
+	ch(err, t)
+	if v, err := Get(dest, []byte("after")); err != nil || string(v) != "after" {
+		t.Error("not equal", string(v), err)
+	}
+}
+
+func TestRestoreStream(t *testing.T) {
+	f := "tests/TestRestoreStream.db"
+	dest := "tests/TestRestoreStreamRestored.db"
+	DeleteFile(f)
+	DeleteFile(dest)
+	defer CloseAll()
+	ch(Set(f, []byte("key"), []byte("val")), t)
+	var buf bytes.Buffer
+	ch(Backup(f, &buf), t)
+
+	// other stores are used while archive is read
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- Restore(pr, dest)
+	}()
+	pw.Write(buf.Bytes()[:HEADER_SIZE])
+	ch(Set(f, []byte("other"), []byte("other")), t)
+	if _, err := Open(dest); err != ErrLocked {
+		t.Error("opened while restored", err)
+	}
+	pw.Write(buf.Bytes()[HEADER_SIZE:])
+	pw.Close()
+	ch(<-done, t)
+	if v, err := Get(dest, []byte("key")); err != nil || string(v) != "val" {
+		t.Error("not equal", string(v), err)
+	}
+
+	// size of key is not allocated before key is read
+	b := append(append([]byte{}, buf.Bytes()[:HEADER_SIZE]...), cmdSet, 0xff, 0xff, 0xff, 0xff, 0x0f, 'k')
+	ch(Close(dest), t)
+	if err := Restore(bytes.NewReader(b), dest); err != ErrCorrupted {
+		t.Error("broken archive restored", err)
+	}
+}
@@ -0,0 +1,123 @@
+package gig
+
+import (
+	"bytes"
+)
+
+// batchOp - put or delete of key in write batch
+// Range delete is written only by Follower, then key is start of range and val is its end
+type batchOp struct {
+	cmd    uint8
+	key    []byte
+	val    []byte
+	expire int64
+}
+
+// WriteBatch collect puts and deletes, which will be written atomically by Write
+// After crash all operations of batch are visible or none of them
+type WriteBatch struct {
+	ops []batchOp
+}
+
+type batchRequest struct {
+	ops          []batchOp
+	responseChan chan writeResponse
+}
+
+// Put add key with val to batch
+func (b *WriteBatch) Put(key, val []byte) {
+	b.ops = append(b.ops, batchOp{cmd: cmdSet, key: append([]byte{}, key...), val: append([]byte{}, val...)})
+}
+
+// Delete add delete of key to batch
+func (b *WriteBatch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{cmd: cmdDelete, key: append([]byte{}, key...)})
+}
+
+// Len return count of operations in batch
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset remove all operations from batch, so it may be used again
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// writeBatch append values, key records and commit record with sync
+// Values never written in place, so old values stay valid until commit
+// On error keys file truncated, so next batch will not continue this one
+func (s *store) writeBatch(ops []batchOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if s.version == 0 {
+		return ErrNeedUpgrade
+	}
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+
+	cmds := make([]*Cmd, len(ops))
+	offsets := make([]int64, len(ops))
+	var valSeek int64 = -1
+	var valLen int
+	for i, op := range ops {
+		rec := &keyRecord{version: s.version, cmd: op.cmd, key: op.key}
+		switch op.cmd {
+		case cmdDeleteRange:
+			rec.size = uint32(len(op.key))
+			rec.key = append(append([]byte{}, op.key...), op.val...)
+		case cmdSet:
+			val, cmd := s.encodeVal(op.key, op.val)
+			cmd.Expire = op.expire
+			seek, _, err := writeAtPos(s.fv, val, int64(-1), false)
+			if err != nil {
+				return err
+			}
+			cmd.Seek = uint64(seek)
+			rec = cmd.record(s.version, op.key)
+			cmds[i] = cmd
+			valSeek, valLen = seek, len(val)
+		}
+		rec.cmd |= flagBatch
+		offsets[i] = int64(buf.Len())
+		if err := encodeKey(buf, rec); err != nil {
+			return err
+		}
+	}
+	if err := encodeKey(buf, &keyRecord{version: s.version, cmd: cmdCommit, size: uint32(len(ops))}); err != nil {
+		return err
+	}
+	if s.syncAlways() {
+		// values must be on disk before commit record
+		if err := s.fv.Sync(); err != nil {
+			return err
+		}
+	} else {
+		s.valDirty = true
+	}
+	start, _, err := writeAtPos(s.fk, buf.Bytes(), int64(-1), false)
+	if err != nil {
+		if start > 0 {
+			s.fk.Truncate(start)
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	for i, op := range ops {
+		switch op.cmd {
+		case cmdSet:
+			cmds[i].KeySeek = uint64(start + offsets[i])
+			s.setCmd(op.key, cmds[i], len(op.val))
+		case cmdDeleteRange:
+			s.delRange(op.key, op.val)
+		default:
+			s.delCmd(op.key)
+		}
+	}
+	s.mu.Unlock()
+	s.grow(start, valSeek, buf.Len(), valLen)
+	return s.written()
+}
@@ -0,0 +1,97 @@
+package gig
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	f := "tests/TestWriteBatch.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("old"), []byte("val")), t)
+	ch(Set(f, []byte("keep"), []byte("val")), t)
+
+	var b WriteBatch
+	b.Put([]byte("a"), []byte("1"))
+	b.Put([]byte("b"), []byte("2"))
+	b.Put([]byte("keep"), []byte("new"))
+	b.Delete([]byte("old"))
+	ch(Write(f, &b), t)
+	check := func() {
+		keys, _ := Keys(f, nil, 0, 0, true)
+		if len(keys) != 3 || string(keys[0]) != "a" || string(keys[1]) != "b" || string(keys[2]) != "keep" {
+			t.Error("wrong keys", keys)
+		}
+		if v, err := Get(f, []byte("keep")); err != nil || string(v) != "new" {
+			t.Error("not updated", string(v), err)
+		}
+	}
+	check()
+	Close(f)
+	check()
+	r, err := Check(f)
+	ch(err, t)
+	if !r.Ok() || r.Batches != 1 {
+		t.Error("wrong report", r)
+	}
+
+	b.Reset()
+	if b.Len() != 0 {
+		t.Error("not reset")
+	}
+	ch(Write(f, &b), t)
+}
+
+func TestWriteBatchUncommitted(t *testing.T) {
+	f := "tests/TestWriteBatchUncommitted.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("keep"), []byte("val")), t)
+	var b WriteBatch
+	b.Put([]byte("keep"), []byte("new"))
+	b.Put([]byte("a"), []byte("1"))
+	b.Delete([]byte("b"))
+	ch(Write(f, &b), t)
+	Close(f)
+
+	// cut commit record off, like crash before it was written
+	info, err := os.Stat(f + KEY_FILE_EXT)
+	ch(err, t)
+	ch(os.Truncate(f+KEY_FILE_EXT, info.Size()-keyRecordSize(FORMAT_VERSION, cmdCommit, nil)), t)
+	if v, err := Get(f, []byte("keep")); err != nil || string(v) != "val" {
+		t.Error("uncommitted batch applied", string(v), err)
+	}
+	if has, _ := Has(f, []byte("a")); has {
+		t.Error("key of uncommitted batch found")
+	}
+	rec, _ := Recovered(f)
+	if rec == nil || rec.Err != errUncommitted {
+		t.Error("uncommitted batch not reported", rec)
+	}
+
+	// records of discarded batch are truncated and not joined to next batch
+	b.Reset()
+	b.Put([]byte("c"), []byte("3"))
+	ch(Write(f, &b), t)
+	Close(f)
+	if cnt, _ := Count(f); cnt != 2 {
+		t.Error("wrong count", cnt)
+	}
+	if has, _ := Has(f, []byte("a")); has {
+		t.Error("key of discarded batch found")
+	}
+}
+
+func TestWriteBatchFormatV0(t *testing.T) {
+	f := "tests/TestWriteBatchFormatV0.db"
+	createV0(t, f, 1)
+	defer CloseAll()
+	var b WriteBatch
+	b.Put([]byte("key"), []byte("val"))
+	if err := Write(f, &b); err != ErrNeedUpgrade {
+		t.Error("batch in version 0", err)
+	}
+	// Sets still works in version 0
+	ch(Sets(f, [][]byte{[]byte("key"), []byte("val")}), t)
+}
@@ -0,0 +1,146 @@
+package gig
+
+// Keys file is log of changes in commit order, so changes are read from it by sequence number
+// Sequence number of change is generation of keys file and offset after its record,
+// compaction increase generation, so sequence numbers are always increased
+// Compaction copy live keys to new keys file, so changes of it start with all keys,
+// reader caught up with end of old keys file continue after copied keys
+
+const (
+	// seqShift - offset in keys file stored in low bits of sequence number
+	seqShift = 40
+	// changesChunk - size of part of keys file read at once, bigger record read whole
+	changesChunk = 64 * 1024
+)
+
+// Change - change of store read from keys file, see ChangesSince
+type Change struct {
+	// Seq - sequence number of change, last change of batch has sequence number of its commit
+	Seq uint64
+	// Op - EVENT_SET, EVENT_DELETE or EVENT_DELETE_RANGE
+	Op  EventOp
+	Key []byte
+	// End - end of deleted range (excluded), empty - to last key
+	End []byte
+	// Value - value of EVENT_SET, nil if its checksum does not match, so value is damaged
+	// or overwritten in place by later change of key
+	Value []byte
+	// Expire - expiration time of EVENT_SET, see Cmd
+	Expire int64
+}
+
+type changesResponse struct {
+	changes []Change
+	seq     uint64
+	err     error
+}
+
+type changesRequest struct {
+	seq uint64
+	// limit < 0 - only last sequence number returned
+	limit        int
+	responseChan chan changesResponse
+}
+
+// seq return sequence number of change with record ended at offset
+func (s *store) seq(offset int64) uint64 {
+	return s.generation<<seqShift | uint64(offset)
+}
+
+// changesSince read records after change seq, seq 0 - from first record
+// Records of batch returned with commit record, so batch is not split by limit
+func (s *store) changesSince(seq uint64, limit int) ([]Change, error) {
+	if s.version < 2 {
+		// value without checksum may be overwritten
+		return nil, ErrNeedUpgrade
+	}
+	offset := int64(HEADER_SIZE)
+	if seq != 0 && seq == s.compactedSeq {
+		seq = s.seq(s.compactedEnd)
+	}
+	if seq != 0 {
+		offset = int64(seq & (1<<seqShift - 1))
+		if seq>>seqShift != s.generation || offset < HEADER_SIZE || offset > s.keySize {
+			return nil, ErrCompacted
+		}
+	}
+	var changes, pending []Change
+	var buf, b []byte
+	// b - read bytes of keys file from offset
+	for offset < s.keySize && (limit <= 0 || len(changes) < limit) {
+		rec, n, err := decodeKey(b)
+		if err == errTruncated && offset+int64(len(b)) < s.keySize {
+			// record continued in next part
+			size := min(max(changesChunk, 2*int64(len(b))), s.keySize-offset)
+			if int64(cap(buf)) < size {
+				buf = make([]byte, size)
+			}
+			b = buf[:size]
+			if _, err = s.fk.ReadAt(b, offset); err != nil {
+				return changes, err
+			}
+			continue
+		}
+		if err != nil {
+			return changes, ErrCorrupted
+		}
+		b = b[n:]
+		offset += int64(n)
+		if rec.cmd&cmdMask == cmdCommit {
+			if int(rec.size) == len(pending) && len(pending) > 0 {
+				// reader of last change is caught up with commit, see compactedSeq
+				pending[len(pending)-1].Seq = s.seq(offset)
+				changes = append(changes, pending...)
+			}
+			pending = nil
+			continue
+		}
+		c, err := s.change(rec, s.seq(offset))
+		if err != nil {
+			return changes, err
+		}
+		if rec.cmd&flagBatch != 0 {
+			pending = append(pending, c)
+			continue
+		}
+		// batch abandoned after failed write
+		pending = nil
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// change return change of key record
+// Value may be damaged or overwritten in place by later change of key, then its checksum is not matched
+func (s *store) change(rec keyRecord, seq uint64) (Change, error) {
+	c := Change{Seq: seq, Key: append([]byte{}, rec.key...)}
+	switch rec.cmd & cmdMask {
+	case cmdSet:
+		c.Op = EVENT_SET
+		c.Expire = rec.expire
+		cmd := &Cmd{Seek: rec.seek, Size: rec.size, CRC: rec.crc, Codec: rec.codec(), Sealed: rec.cmd&flagSealed != 0}
+		b, err := readStored(s.fv, s.version, cmd)
+		if err == ErrCorrupted {
+			return c, nil
+		}
+		if err == nil {
+			c.Value, err = openVal(s.version, s.aead, rec.key, cmd, b)
+		}
+		if err == nil && c.Value == nil {
+			// nil is value of damaged change only
+			c.Value = []byte{}
+		}
+		return c, err
+	case cmdDelete:
+		c.Op = EVENT_DELETE
+	case cmdDeleteRange:
+		if int(rec.size) > len(c.Key) {
+			return c, ErrCorrupted
+		}
+		c.Op = EVENT_DELETE_RANGE
+		c.Key, c.End = c.Key[:rec.size], c.Key[rec.size:]
+	default:
+		return c, ErrUnknownCommand
+	}
+	return c, nil
+}
@@ -0,0 +1,148 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChangesSince(t *testing.T) {
+	f := "tests/TestChangesSince.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	ch(Set(f, []byte("b"), []byte("2")), t)
+	// longer value appended, so old value stays in changes
+	ch(Set(f, []byte("a"), []byte("33")), t)
+	batch := &WriteBatch{}
+	batch.Put([]byte("c"), []byte("4"))
+	batch.Delete([]byte("b"))
+	ch(Write(f, batch), t)
+	_, err := DeleteRange(f, []byte("a"), []byte("b"))
+	ch(err, t)
+	ch(Set(f, []byte("d"), []byte("5")), t)
+
+	format := func(changes []Change) string {
+		var s []string
+		for _, c := range changes {
+			s = append(s, fmt.Sprintf("%d %s%s %s", c.Op, c.Key, c.End, c.Value))
+		}
+		return strings.Join(s, ",")
+	}
+	changes, err := ChangesSince(f, 0, 0)
+	ch(err, t)
+	if s := format(changes); s != "0 a 1,0 b 2,0 a 33,0 c 4,1 b ,3 ab ,0 d 5" {
+		t.Errorf("wrong changes %q", s)
+	}
+	for i := 1; i < len(changes); i++ {
+		if changes[i].Seq <= changes[i-1].Seq {
+			t.Error("sequence not increased", changes[i-1].Seq, changes[i].Seq)
+		}
+	}
+	last, err := LastSeq(f)
+	ch(err, t)
+	if last != changes[len(changes)-1].Seq {
+		t.Error("wrong last sequence", last)
+	}
+
+	// resume by pages, batch is not split
+	var seq uint64
+	var pages []string
+	for {
+		page, err := ChangesSince(f, seq, 3)
+		ch(err, t)
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, format(page))
+		seq = page[len(page)-1].Seq
+	}
+	if s := strings.Join(pages, "|"); s != "0 a 1,0 b 2,0 a 33|0 c 4,1 b ,3 ab |0 d 5" {
+		t.Errorf("wrong pages %q", s)
+	}
+
+	// sequence kept after reopen
+	Close(f)
+	if page, err := ChangesSince(f, changes[4].Seq, 0); err != nil || format(page) != "3 ab ,0 d 5" {
+		t.Error("wrong changes", format(page), err)
+	}
+
+	_, err = Compact(f)
+	ch(err, t)
+	if _, err = ChangesSince(f, changes[4].Seq, 0); err != ErrCompacted {
+		t.Error("compacted history returned", err)
+	}
+	changes, err = ChangesSince(f, 0, 0)
+	ch(err, t)
+	if s := format(changes); s != "0 c 4,0 d 5" {
+		t.Errorf("wrong changes %q", s)
+	}
+	if changes[0].Seq <= last {
+		t.Error("sequence not increased", changes[0].Seq, last)
+	}
+	if page, err := ChangesSince(f, last, 0); err != nil || len(page) != 0 {
+		t.Error("reader caught up before compaction not continued", format(page), err)
+	}
+
+	// reader caught up with batch continue after next compaction too
+	batch = &WriteBatch{}
+	batch.Put([]byte("e"), []byte("6"))
+	batch.Delete([]byte("c"))
+	ch(Write(f, batch), t)
+	page, err := ChangesSince(f, changes[1].Seq, 0)
+	ch(err, t)
+	if last, err = LastSeq(f); err != nil || page[len(page)-1].Seq != last {
+		t.Error("last change of batch is not last sequence", page[len(page)-1].Seq, last, err)
+	}
+	_, err = Compact(f)
+	ch(err, t)
+	ch(Set(f, []byte("f"), []byte("7")), t)
+	if page, err := ChangesSince(f, last, 0); err != nil || format(page) != "0 f 7" {
+		t.Error("wrong changes", format(page), err)
+	}
+	if _, err = ChangesSince(f, changes[1].Seq, 0); err != ErrCompacted {
+		t.Error("compacted history returned", err)
+	}
+}
+
+func TestChangesSinceChunks(t *testing.T) {
+	f := "tests/TestChangesSinceChunks.db"
+	DeleteFile(f)
+	defer CloseAll()
+	// records of long keys cross parts of keys file, one is bigger than part
+	for i := 0; i < 100; i++ {
+		ch(Set(f, []byte(fmt.Sprintf("%04d%01000d", i, 0)), []byte{byte(i)}), t)
+	}
+	big := bytes.Repeat([]byte("k"), 3*changesChunk)
+	ch(Set(f, big, []byte("big")), t)
+	ch(Set(f, []byte("last"), []byte("last")), t)
+
+	changes, err := ChangesSince(f, 0, 0)
+	ch(err, t)
+	if len(changes) != 102 || !bytes.Equal(changes[100].Key, big) || string(changes[101].Value) != "last" {
+		t.Error("wrong changes", len(changes))
+	}
+	for i, c := range changes[:100] {
+		if len(c.Value) != 1 || int(c.Value[0]) != i {
+			t.Error("wrong value", i, c.Value)
+		}
+	}
+	var seq uint64
+	n := 0
+	for {
+		page, err := ChangesSince(f, seq, 7)
+		ch(err, t)
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 7 || page[0].Seq != changes[n].Seq {
+			t.Fatal("wrong page", n, len(page))
+		}
+		n += len(page)
+		seq = page[len(page)-1].Seq
+	}
+	if n != len(changes) {
+		t.Error("wrong count of paged changes", n)
+	}
+}
@@ -0,0 +1,179 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Checkpoint file has index of store at some offset of keys file, so only records
+// after this offset replayed on open. Checkpoint starts with header of HEADER_SIZE bytes:
+// magic(4) version(1) reserved(3) generation(8) offset(8) count(8)
+// then count of set records in format of keys file ordered by key and CRC(4) of all bytes before.
+// Checkpoint of other generation or with wrong checksum is ignored and all records replayed.
+
+var checkpointMagic = []byte("GIGC")
+
+// checkpointResult send by background writer to run goroutine
+type checkpointResult struct {
+	generation uint64
+	offset     int64
+	err        error
+}
+
+// writeCheckpoint write index to temp file, then rename it over checkpoint file
+// Keys file must be synced up to offset before
+func writeCheckpoint(file string, version uint8, generation uint64, offset int64, index *node) error {
+	tmp := file + CHECKPOINT_FILE_EXT + COMPACT_FILE_EXT
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, FILE_MODE)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := crc32.New(crcTable)
+	w := bufio.NewWriter(io.MultiWriter(f, h))
+
+	header := make([]byte, HEADER_SIZE)
+	copy(header, checkpointMagic)
+	header[len(checkpointMagic)] = version
+	binary.BigEndian.PutUint64(header[8:], generation)
+	binary.BigEndian.PutUint64(header[16:], uint64(offset))
+	binary.BigEndian.PutUint64(header[24:], uint64(index.len()))
+	w.Write(header)
+	var buf bytes.Buffer
+	index.ascend(0, func(n *node) bool {
+		buf.Reset()
+		if err = encodeKey(&buf, n.cmd.record(version, n.key)); err == nil {
+			_, err = w.Write(buf.Bytes())
+		}
+		return err == nil
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = w.Flush(); err == nil {
+		err = binary.Write(f, binary.BigEndian, h.Sum32())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, file+CHECKPOINT_FILE_EXT)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(file)
+}
+
+// loadCheckpoint load index from checkpoint file if it match keys file
+// Return offset of keys file from which records must be replayed
+func (s *store) loadCheckpoint() int64 {
+	if s.version == 0 {
+		return 0
+	}
+	b, err := ioutil.ReadFile(s.file + CHECKPOINT_FILE_EXT)
+	if err != nil || len(b) < HEADER_SIZE+4 || !bytes.Equal(b[:len(checkpointMagic)], checkpointMagic) {
+		return 0
+	}
+	if b[len(checkpointMagic)] != s.version || binary.BigEndian.Uint64(b[8:]) != s.generation {
+		return 0
+	}
+	body := b[:len(b)-4]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(b[len(body):]) {
+		return 0
+	}
+	offset := int64(binary.BigEndian.Uint64(b[16:]))
+	info, err := s.fk.Stat()
+	if err != nil || offset < HEADER_SIZE || offset > info.Size() {
+		return 0
+	}
+	count := binary.BigEndian.Uint64(b[24:])
+	readSeek := HEADER_SIZE
+	for readSeek < len(body) {
+		rec, n, err := decodeKey(body[readSeek:])
+		if err != nil || rec.cmd&cmdMask != cmdSet {
+			s.reset()
+			return 0
+		}
+		readSeek += n
+		s.setCmd(append([]byte{}, rec.key...), &Cmd{
+			Seek:    rec.seek,
+			Size:    rec.size,
+			CRC:     rec.crc,
+			KeySeek: uint64(offset),
+			Expire:  rec.expire,
+			Codec:   rec.codec(),
+			Sealed:  rec.cmd&flagSealed != 0,
+		}, int(rec.size))
+	}
+	if uint64(len(s.valDict)) != count {
+		s.reset()
+		return 0
+	}
+	s.checkpointed = offset
+	return offset
+}
+
+// startCheckpoint write checkpoint in background
+// Index is never changed in place, so root is snapshot of keys
+func (s *store) startCheckpoint() {
+	if s.checkpointing != nil || s.version == 0 || s.keySize == s.checkpointed {
+		return
+	}
+	// values of keys in checkpoint must be on disk before it, see sync
+	if err := s.sync(); err != nil {
+		return
+	}
+	done := make(chan checkpointResult, 1)
+	res := checkpointResult{generation: s.generation, offset: s.keySize}
+	file, version, index := s.file, s.version, s.index
+	go func() {
+		res.err = writeCheckpoint(file, version, res.generation, res.offset, index)
+		done <- res
+	}()
+	s.checkpointing = done
+}
+
+// finishCheckpoint remember offset covered by written checkpoint
+// Checkpoint written before compaction describe old keys file, so it is ignored
+func (s *store) finishCheckpoint(res checkpointResult) {
+	s.checkpointing = nil
+	if res.err == nil && res.generation == s.generation {
+		s.checkpointed = res.offset
+	}
+}
+
+// checkpoint wait checkpoint in background and write new one if keys changed
+func (s *store) checkpoint() error {
+	if s.checkpointing != nil {
+		s.finishCheckpoint(<-s.checkpointing)
+	}
+	if s.version == 0 {
+		return ErrNeedUpgrade
+	}
+	if s.keySize == s.checkpointed {
+		return nil
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+	err := writeCheckpoint(s.file, s.version, s.generation, s.keySize, s.index)
+	if err == nil {
+		s.checkpointed = s.keySize
+	}
+	return err
+}
+
+// autoCheckpoint start checkpoint if enough records written after last one
+func (s *store) autoCheckpoint() {
+	if !s.readOnly && CheckpointBytes > 0 && s.keySize-s.checkpointed >= CheckpointBytes {
+		s.startCheckpoint()
+	}
+}
@@ -0,0 +1,138 @@
+package gig
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	f := "tests/TestCheckpoint.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	ch(Checkpoint(f), t)
+	Close(f)
+	old, err := os.ReadFile(f + CHECKPOINT_FILE_EXT)
+	ch(err, t)
+
+	// only tail after old checkpoint replayed
+	for i := 0; i < 10; i++ {
+		Delete(f, []byte(fmt.Sprintf("%04d", i)))
+		ch(Set(f, []byte(fmt.Sprintf("%04d", i+100)), []byte("new")), t)
+	}
+	Close(f)
+	ch(os.WriteFile(f+CHECKPOINT_FILE_EXT, old, FILE_MODE), t)
+	// record covered by checkpoint damaged, but it is not read
+	b, err := os.ReadFile(f + KEY_FILE_EXT)
+	ch(err, t)
+	b[HEADER_SIZE+1] = 0x07
+	ch(os.WriteFile(f+KEY_FILE_EXT, b, FILE_MODE), t)
+	check := func() {
+		if cnt, _ := Count(f); cnt != 100 {
+			t.Error("wrong count", cnt)
+		}
+		if has, _ := Has(f, []byte("0005")); has {
+			t.Error("deleted key found")
+		}
+		if v, err := Get(f, []byte("0105")); err != nil || string(v) != "new" {
+			t.Error("key of tail not found", string(v), err)
+		}
+		if v, err := Get(f, []byte("0050")); err != nil || string(v) != "0050" {
+			t.Error("key of checkpoint not found", string(v), err)
+		}
+	}
+	check()
+	if rec, _ := Recovered(f); rec != nil {
+		t.Error("records of checkpoint replayed", rec)
+	}
+	Close(f)
+
+	// damaged checkpoint ignored, all records replayed
+	b[HEADER_SIZE+1] = cmdSet
+	ch(os.WriteFile(f+KEY_FILE_EXT, b, FILE_MODE), t)
+	old[len(old)-10]++
+	ch(os.WriteFile(f+CHECKPOINT_FILE_EXT, old, FILE_MODE), t)
+	check()
+	Close(f)
+	os.Remove(f + CHECKPOINT_FILE_EXT)
+	check()
+}
+
+func TestCheckpointCompact(t *testing.T) {
+	f := "tests/TestCheckpointCompact.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+		ch(Set(f, k, []byte(fmt.Sprintf("%08d", i))), t)
+	}
+	ch(Checkpoint(f), t)
+	Close(f)
+	old, err := os.ReadFile(f + CHECKPOINT_FILE_EXT)
+	ch(err, t)
+	_, err = Compact(f)
+	ch(err, t)
+	ch(Checkpoint(f), t)
+	Close(f)
+
+	// checkpoint of keys file before compaction is ignored
+	ch(os.WriteFile(f+CHECKPOINT_FILE_EXT, old, FILE_MODE), t)
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		if v, err := Get(f, k); err != nil || string(v) != fmt.Sprintf("%08d", i) {
+			t.Error("not equal", string(k), string(v), err)
+		}
+	}
+}
+
+func TestAutoCheckpoint(t *testing.T) {
+	f := "tests/TestAutoCheckpoint.db"
+	DeleteFile(f)
+	interval, size := SweepInterval, CheckpointBytes
+	SweepInterval, CheckpointBytes = 10*time.Millisecond, 256
+	defer func() {
+		// store goroutine must be stopped before settings restored
+		CloseAll()
+		SweepInterval, CheckpointBytes = interval, size
+	}()
+	for i := 0; i < 20; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	var err error
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(f + CHECKPOINT_FILE_EXT); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Error("checkpoint not written", err)
+	}
+}
+
+func TestCheckpointSync(t *testing.T) {
+	f := "tests/TestCheckpointSync.db"
+	DeleteFile(f)
+	defer CloseAll()
+	db, err := OpenWithOptions(f, &Options{Sync: SYNC_NONE})
+	ch(err, t)
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	if db.s.unsynced == 0 || !db.s.valDirty {
+		t.Fatal("writes synced in mode SYNC_NONE")
+	}
+	// values of checkpoint are synced with keys
+	ch(Checkpoint(f), t)
+	if db.s.unsynced != 0 || db.s.valDirty {
+		t.Error("values not synced before checkpoint", db.s.unsynced, db.s.valDirty)
+	}
+}
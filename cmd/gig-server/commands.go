@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azhai/gig"
+)
+
+// client - connection of Redis client
+type client struct {
+	*server
+	r *bufio.Reader
+	w *bufio.Writer
+	// db - index of selected store
+	db     int
+	authed bool
+	quit   bool
+}
+
+// command - handler of Redis command
+type command struct {
+	// arity - count of arguments with name of command, negative - min count
+	arity int
+	// run get arguments without name of command
+	run func(c *client, args [][]byte)
+}
+
+var commands = map[string]command{
+	"GET":     {2, (*client).get},
+	"SET":     {-3, (*client).set},
+	"DEL":     {-2, (*client).del},
+	"EXISTS":  {-2, (*client).exists},
+	"INCR":    {2, (*client).incr},
+	"SCAN":    {-2, (*client).scan},
+	"MGET":    {-2, (*client).mget},
+	"MSET":    {-3, (*client).mset},
+	"DBSIZE":  {1, (*client).dbsize},
+	"SELECT":  {2, (*client).selectDB},
+	"AUTH":    {-2, (*client).auth},
+	"PING":    {-1, (*client).ping},
+	"ECHO":    {2, (*client).echo},
+	"QUIT":    {1, (*client).quitConn},
+	"COMMAND": {-1, (*client).command},
+}
+
+var (
+	errSyntax    = replyError("ERR syntax error")
+	errNotNumber = replyError("ERR value is not an integer or out of range")
+	errNoAuth    = replyError("NOAUTH Authentication required.")
+)
+
+// handle serve requests of client until it disconnected
+// Replies of pipelined requests written at once
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	c := &client{server: s, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), authed: password == ""}
+	for !c.quit {
+		if idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		args, err := readRequest(c.r, c.authed)
+		if err != nil {
+			if _, ok := err.(protocolError); ok {
+				c.error(err)
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) > 0 {
+			c.exec(args)
+		}
+		if c.r.Buffered() == 0 && c.w.Flush() != nil {
+			return
+		}
+	}
+	c.w.Flush()
+}
+
+// exec check arguments and run command
+func (c *client) exec(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.error(replyError("ERR unknown command '" + string(args[0]) + "'"))
+		return
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		c.error(replyError("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command"))
+		return
+	}
+	if !c.authed && name != "AUTH" && name != "QUIT" {
+		c.error(errNoAuth)
+		return
+	}
+	cmd.run(c, args[1:])
+}
+
+// file return file of selected store
+func (c *client) file() string {
+	return c.files[c.db]
+}
+
+func (c *client) get(args [][]byte) {
+	val, err := gig.Get(c.file(), args[0])
+	switch {
+	case err == gig.ErrKeyNotFound:
+		c.bulk(nil)
+	case err != nil:
+		c.error(err)
+	case val == nil:
+		c.bulk([]byte{})
+	default:
+		c.bulk(val)
+	}
+}
+
+// set support options EX seconds and PX milliseconds
+func (c *client) set(args [][]byte) {
+	var ttl time.Duration
+	for i := 2; i < len(args); i += 2 {
+		opt := strings.ToUpper(string(args[i]))
+		if i+1 >= len(args) || opt != "EX" && opt != "PX" {
+			c.error(errSyntax)
+			return
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || n <= 0 {
+			c.error(replyError("ERR invalid expire time in 'set' command"))
+			return
+		}
+		ttl = time.Duration(n) * time.Millisecond
+		if opt == "EX" {
+			ttl = time.Duration(n) * time.Second
+		}
+	}
+	var err error
+	if ttl > 0 {
+		err = gig.SetWithTTL(c.file(), args[0], args[1], ttl)
+	} else {
+		err = gig.Set(c.file(), args[0], args[1])
+	}
+	if err != nil {
+		c.error(err)
+		return
+	}
+	c.simple("OK")
+}
+
+// del delete keys, only existing keys are counted
+// Key deleted by gig.DeleteRange of the key only, so it is checked and deleted at once,
+// in stores of version 0 by gig.Has and gig.Delete, then SET of other client may come between them
+func (c *client) del(args [][]byte) {
+	var n int64
+	for _, key := range args {
+		deleted, err := gig.DeleteRange(c.file(), key, append(key[:len(key):len(key)], 0))
+		if err == gig.ErrNeedUpgrade {
+			var has bool
+			if has, err = gig.Has(c.file(), key); err == nil && has {
+				_, err = gig.Delete(c.file(), key)
+				deleted = 1
+			}
+		}
+		if err != nil {
+			c.error(err)
+			return
+		}
+		n += int64(deleted)
+	}
+	c.integer(n)
+}
+
+func (c *client) exists(args [][]byte) {
+	var n int64
+	for _, key := range args {
+		has, err := gig.Has(c.file(), key)
+		if err != nil {
+			c.error(err)
+			return
+		}
+		if has {
+			n++
+		}
+	}
+	c.integer(n)
+}
+
+// incr increment counter by gig.Counter, value of other keys is not a counter
+// gig.Counter read and then write key, INCR of clients are serialized by server,
+// but SET or DEL of the key between read and write is lost
+func (c *client) incr(args [][]byte) {
+	c.counters.Lock()
+	n, err := gig.Counter(c.file(), args[0])
+	c.counters.Unlock()
+	switch {
+	case err == gig.ErrKeyNotFound:
+		c.error(errNotNumber)
+	case err != nil:
+		c.error(err)
+	default:
+		c.integer(int64(n))
+	}
+}
+
+// scan return keys from offset cursor, next cursor is 0 after last key
+func (c *client) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 32)
+	if err != nil {
+		c.error(replyError("ERR invalid cursor"))
+		return
+	}
+	var from []byte
+	count := uint64(10)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.error(errSyntax)
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			prefix, ok := prefixPattern(string(args[i+1]))
+			if !ok {
+				c.error(replyError("ERR only patterns of prefix like 'prefix*' are supported"))
+				return
+			}
+			from = nil
+			if prefix != "" {
+				from = []byte(prefix + "*")
+			}
+		case "COUNT":
+			if count, err = strconv.ParseUint(string(args[i+1]), 10, 32); err != nil || count == 0 {
+				c.error(errNotNumber)
+				return
+			}
+		default:
+			c.error(errSyntax)
+			return
+		}
+	}
+	keys, err := gig.Keys(c.file(), from, uint32(count), uint32(cursor), true)
+	if err != nil {
+		c.error(err)
+		return
+	}
+	next := cursor + uint64(len(keys))
+	if uint64(len(keys)) < count {
+		next = 0
+	}
+	c.array(2)
+	c.bulk([]byte(strconv.FormatUint(next, 10)))
+	c.array(len(keys))
+	for _, key := range keys {
+		c.bulk(key)
+	}
+}
+
+// prefixPattern return prefix of pattern "prefix*", ok is false for other patterns
+func prefixPattern(pattern string) (prefix string, ok bool) {
+	prefix = strings.TrimSuffix(pattern, "*")
+	if prefix == pattern || strings.ContainsAny(prefix, "*?[\\") {
+		return "", false
+	}
+	return prefix, true
+}
+
+// mget reply values in order of keys, gig.Gets return found pairs in random order
+func (c *client) mget(args [][]byte) {
+	pairs := gig.Gets(c.file(), args)
+	vals := make(map[string][]byte, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		val := pairs[i+1]
+		if val == nil {
+			val = []byte{}
+		}
+		vals[string(pairs[i])] = val
+	}
+	c.array(len(args))
+	for _, key := range args {
+		c.bulk(vals[string(key)])
+	}
+}
+
+func (c *client) mset(args [][]byte) {
+	if len(args)%2 != 0 {
+		c.error(replyError("ERR wrong number of arguments for 'mset' command"))
+		return
+	}
+	if err := gig.Sets(c.file(), args); err != nil {
+		c.error(err)
+		return
+	}
+	c.simple("OK")
+}
+
+func (c *client) dbsize(args [][]byte) {
+	n, err := gig.Count(c.file())
+	if err != nil {
+		c.error(err)
+		return
+	}
+	c.integer(int64(n))
+}
+
+// selectDB select store by index or name of file
+func (c *client) selectDB(args [][]byte) {
+	i, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		var ok bool
+		if i, ok = c.names[string(args[0])]; !ok {
+			c.error(replyError("ERR unknown database name"))
+			return
+		}
+	}
+	if i < 0 || i >= len(c.files) {
+		c.error(replyError("ERR DB index is out of range"))
+		return
+	}
+	c.db = i
+	c.simple("OK")
+}
+
+// auth accept password or user "default" and password
+func (c *client) auth(args [][]byte) {
+	if len(args) > 2 {
+		c.error(errSyntax)
+		return
+	}
+	if password == "" {
+		c.error(replyError("ERR AUTH called without any password configured"))
+		return
+	}
+	user, pass := "default", args[0]
+	if len(args) == 2 {
+		user, pass = string(args[0]), args[1]
+	}
+	if user != "default" || subtle.ConstantTimeCompare(pass, []byte(password)) != 1 {
+		c.authed = false
+		c.error(replyError("WRONGPASS invalid username-password pair or user is disabled."))
+		return
+	}
+	c.authed = true
+	c.simple("OK")
+}
+
+func (c *client) ping(args [][]byte) {
+	switch len(args) {
+	case 0:
+		c.simple("PONG")
+	case 1:
+		c.bulk(args[0])
+	default:
+		c.error(replyError("ERR wrong number of arguments for 'ping' command"))
+	}
+}
+
+func (c *client) echo(args [][]byte) {
+	c.bulk(args[0])
+}
+
+func (c *client) quitConn(args [][]byte) {
+	c.quit = true
+	c.simple("OK")
+}
+
+// command reply empty list, so clients which ask commands on connect work
+func (c *client) command(args [][]byte) {
+	c.array(0)
+}
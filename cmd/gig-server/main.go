@@ -0,0 +1,132 @@
+// gig-server serve gig stores to Redis clients by subset of Redis protocol (RESP)
+//
+// Usage: gig-server [-addr host:port] [-auth password] [-maxconn n] [-idle timeout] file ...
+//
+// Database 0 is first file, SELECT change database by its index or name of file.
+// Commands: GET, SET, DEL, EXISTS, INCR, SCAN, MGET, MSET, DBSIZE, SELECT, AUTH, PING, ECHO, QUIT
+// INCR use gig.Counter, so counters are stored as 8 bytes big endian numbers.
+// INCR is not atomic with other writes: SET or DEL of counter made while it is incremented is lost.
+// SCAN support only patterns of prefix, like "user:*", its cursor is offset of keys
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/azhai/gig"
+)
+
+var (
+	addr        string        //listen address
+	password    string        //password of AUTH, empty - no auth
+	maxConns    int           //max count of clients, 0 - no limit
+	idleTimeout time.Duration //idle client disconnected after it, 0 - never
+)
+
+func init() {
+	flag.StringVar(&addr, "addr", ":6380", "listen on this address")
+	flag.StringVar(&password, "auth", os.Getenv("GIG_AUTH"), "password required by AUTH (default $GIG_AUTH)")
+	flag.IntVar(&maxConns, "maxconn", 1000, "max count of connected clients (0 - no limit)")
+	flag.DurationVar(&idleTimeout, "idle", 0, "close connection of idle client after this time (0 - never)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-addr host:port] [-auth password] [-maxconn n] [-idle timeout] file ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// server - stores and limits shared by clients
+type server struct {
+	files []string
+	// names - index of store by name of file
+	names map[string]int
+	// conns - one item for every connected client, nil - no limit
+	conns chan struct{}
+	// counters - INCR is read and write of key, so it serialized
+	counters sync.Mutex
+}
+
+func newServer(files []string) *server {
+	s := &server{files: files, names: make(map[string]int)}
+	for i, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if _, ok := s.names[name]; !ok {
+			s.names[name] = i
+		}
+	}
+	if maxConns > 0 {
+		s.conns = make(chan struct{}, maxConns)
+	}
+	return s
+}
+
+// serve accept clients until listener closed
+// Clients over limit get error, like from Redis, and disconnected
+func (s *server) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if s.conns == nil {
+			go s.handle(conn)
+			continue
+		}
+		select {
+		case s.conns <- struct{}{}:
+			go func() {
+				s.handle(conn)
+				<-s.conns
+			}()
+		default:
+			conn.Write([]byte("-ERR max number of clients reached\r\n"))
+			conn.Close()
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	s := newServer(flag.Args())
+	for _, file := range s.files {
+		if _, err := gig.Open(file); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			os.Exit(1)
+		}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	stop := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		close(stopped)
+		l.Close()
+	}()
+	err = s.serve(l)
+	// stores synced and closed before exit
+	if cerr := gig.CloseAll(); cerr != nil {
+		fmt.Fprintln(os.Stderr, cerr)
+	}
+	select {
+	case <-stopped:
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/azhai/gig"
+)
+
+// Request is array of bulk strings: *count\r\n then $size\r\nbytes\r\n for every argument,
+// inline request is line of arguments separated by spaces, like from telnet
+// Replies: +simple\r\n, -error\r\n, :integer\r\n, $size\r\nbytes\r\n ($-1 - nil), *count\r\n items
+
+const (
+	// maxArgs - max count of arguments of request
+	maxArgs = 1024 * 1024
+	// maxBulk - max size of argument
+	maxBulk = 64 * 1024 * 1024
+	// bulkChunk - argument read by parts of this size, so memory is taken only for received bytes
+	bulkChunk = 64 * 1024
+	// maxArgsNoAuth and maxBulkNoAuth - limits of request of client not authenticated yet
+	maxArgsNoAuth = 10
+	maxBulkNoAuth = 16 * 1024
+)
+
+// replyError - error with code of Redis, like "NOAUTH Authentication required."
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+// protocolError - request can not be parsed, client disconnected after reply
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// readLine return line without \r\n
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// readSize read size after prefix of line
+func readSize(r *bufio.Reader, prefix byte, max int) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != prefix {
+		return 0, protocolError("expected '" + string(prefix) + "', got '" + string(line) + "'")
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > max {
+		return 0, protocolError("invalid size")
+	}
+	return n, nil
+}
+
+// readBulk return next n bytes, they are read by parts of bulkChunk
+func readBulk(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, 0, min(n, bulkChunk))
+	for len(b) < n {
+		start := len(b)
+		part := min(n-start, bulkChunk)
+		b = slices.Grow(b, part)[:start+part]
+		if _, err := io.ReadFull(r, b[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// readRequest return arguments of next request, empty request has no arguments
+// Request of client not authenticated yet is limited, so it can not take much memory
+func readRequest(r *bufio.Reader, authed bool) ([][]byte, error) {
+	limitArgs, limitBulk := maxArgs, maxBulk
+	if !authed {
+		limitArgs, limitBulk = maxArgsNoAuth, maxBulkNoAuth
+	}
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		var args [][]byte
+		for _, f := range strings.Fields(string(line)) {
+			args = append(args, []byte(f))
+		}
+		return args, nil
+	}
+	n, err := readSize(r, '*', limitArgs)
+	if err != nil {
+		return nil, err
+	}
+	// count is not trusted, so slice grows as arguments are read
+	args := make([][]byte, 0, min(max(n, 0), 1024))
+	for i := 0; i < n; i++ {
+		size, err := readSize(r, '$', limitBulk)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg, err := readBulk(r, size+2)
+		if err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, protocolError("bulk not ended by CRLF")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// replies of client
+
+func (c *client) simple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+// error reply with error, gig errors start with "Error: ", so it removed
+func (c *client) error(err error) {
+	msg := "ERR " + strings.TrimPrefix(err.Error(), "Error: ")
+	if e, ok := err.(replyError); ok {
+		msg = string(e)
+	} else if err == gig.ErrReadOnly {
+		msg = "READONLY You can't write against a read only replica."
+	}
+	c.w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func (c *client) integer(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// bulk reply with b, nil - null bulk
+func (c *client) bulk(b []byte) {
+	if b == nil {
+		c.w.WriteString("$-1\r\n")
+		return
+	}
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+// array start reply with n items
+func (c *client) array(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/azhai/gig"
+)
+
+// serveOne serve one client by handle and return its connection
+func serveOne(t *testing.T, files ...string) net.Conn {
+	client, conn := net.Pipe()
+	go newServer(files).handle(conn)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// exchange write requests at once and return replies read until connection closed
+func exchange(t *testing.T, conn net.Conn, requests string) string {
+	go func() {
+		io.WriteString(conn, requests)
+	}()
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestPipelined(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pipelined.db")
+	if _, err := gig.Open(file); err != nil {
+		t.Fatal(err)
+	}
+	defer gig.CloseAll()
+	conn := serveOne(t, file)
+	got := exchange(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"+
+		"PING\r\n"+
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n"+
+		"*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"+
+		"*2\r\n$6\r\nEXISTS\r\n$1\r\na\r\n"+
+		"*1\r\n$4\r\nQUIT\r\n")
+	want := "+OK\r\n+PONG\r\n$1\r\n1\r\n:1\r\n:0\r\n+OK\r\n"
+	if got != want {
+		t.Errorf("wrong replies %q, want %q", got, want)
+	}
+}
+
+func TestBulkBeforeAuth(t *testing.T) {
+	password = "secret"
+	defer func() { password = "" }()
+	conn := serveOne(t)
+	got := exchange(t, conn, "*2\r\n$4\r\nAUTH\r\n$100000\r\n")
+	if got != "-ERR Protocol error: invalid size\r\n" {
+		t.Errorf("oversized bulk accepted %q", got)
+	}
+
+	// limits of authenticated client
+	args, err := readRequest(bufio.NewReader(strings.NewReader("*1\r\n$100000\r\n"+strings.Repeat("x", 100000)+"\r\n")), true)
+	if err != nil || len(args) != 1 || len(args[0]) != 100000 {
+		t.Error("bulk of authenticated client not read", len(args), err)
+	}
+}
+
+func TestBulkByParts(t *testing.T) {
+	// memory is taken for received bytes, not for announced size
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readRequest(bufio.NewReader(strings.NewReader("*1\r\n$60000000\r\nabc")), true)
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Error("truncated bulk read", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1024*1024 {
+		t.Error("memory taken for announced size", n)
+	}
+	if _, err = readRequest(bufio.NewReader(strings.NewReader("*1\r\n$100000000\r\n")), true); err == nil {
+		t.Error("bulk bigger then limit accepted")
+	}
+}
+
+func TestBadCRLF(t *testing.T) {
+	_, err := readRequest(bufio.NewReader(strings.NewReader("*1\r\n$4\r\nPINGxx")), true)
+	if _, ok := err.(protocolError); !ok {
+		t.Error("bulk without CRLF read", err)
+	}
+	conn := serveOne(t)
+	got := exchange(t, conn, "*1\r\n$4\r\nPING\n\r")
+	if got != "-ERR Protocol error: bulk not ended by CRLF\r\n" {
+		t.Errorf("wrong reply %q", got)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/azhai/gig"
+	"github.com/azhai/gig/helpers"
+)
+
+// loadBatch - count of keys written by one batch of load
+const loadBatch = 1000
+
+// record - line of dump, key and value are strings if they are valid UTF-8, else hex
+type record struct {
+	Key      string `json:"key,omitempty"`
+	KeyHex   string `json:"key_hex,omitempty"`
+	Value    string `json:"value,omitempty"`
+	ValueHex string `json:"value_hex,omitempty"`
+	// TTL - time to live in milliseconds, 0 - key never expire
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// decodeArg return argument as bytes, isHex - argument is hex
+func decodeArg(s string, isHex bool) ([]byte, error) {
+	if !isHex {
+		return []byte(s), nil
+	}
+	return hex.DecodeString(s)
+}
+
+// checkFormat return error if format of values is unknown
+func checkFormat(format string, formats ...string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// printValue print value in format:
+// raw - value as is, hex - helpers.Bin2Hex, json - indented JSON,
+// value which is not JSON printed as is or as hex, if it is not UTF-8
+func printValue(w io.Writer, val []byte, format string) error {
+	var err error
+	switch format {
+	case "hex":
+		_, err = fmt.Fprintln(w, helpers.Bin2Hex(val))
+	case "json":
+		var buf bytes.Buffer
+		if json.Indent(&buf, val, "", "  ") == nil {
+			buf.WriteByte('\n')
+			_, err = w.Write(buf.Bytes())
+		} else if utf8.Valid(val) {
+			_, err = fmt.Fprintln(w, string(val))
+		} else {
+			_, err = fmt.Fprintln(w, helpers.Bin2Hex(val))
+		}
+	default:
+		_, err = w.Write(val)
+	}
+	return err
+}
+
+func get(fs *flag.FlagSet, args []string) error {
+	format := fs.String("f", "raw", "print value as raw, hex or json")
+	isHex := fs.Bool("x", false, "key is hex")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	if err := checkFormat(*format, "raw", "hex", "json"); err != nil {
+		return err
+	}
+	file := storeName(fs.Arg(0))
+	key, err := decodeArg(fs.Arg(1), *isHex)
+	if err != nil {
+		return err
+	}
+	if err = openRead(file); err != nil {
+		return err
+	}
+	val, err := gig.Get(file, key)
+	if err != nil {
+		return err
+	}
+	return printValue(os.Stdout, val, *format)
+}
+
+func set(fs *flag.FlagSet, args []string) error {
+	isHex := fs.Bool("x", false, "key and value are hex")
+	ttl := fs.Duration("ttl", 0, "key expire after this time (0 - never)")
+	fs.Parse(args)
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		return errUsage
+	}
+	file := storeName(fs.Arg(0))
+	key, err := decodeArg(fs.Arg(1), *isHex)
+	if err != nil {
+		return err
+	}
+	var val []byte
+	if fs.NArg() == 3 {
+		val, err = decodeArg(fs.Arg(2), *isHex)
+	} else if val, err = io.ReadAll(os.Stdin); err == nil && *isHex {
+		val, err = hex.DecodeString(string(bytes.TrimSpace(val)))
+	}
+	if err != nil {
+		return err
+	}
+	if err = openWrite(file); err != nil {
+		return err
+	}
+	if *ttl > 0 {
+		return gig.SetWithTTL(file, key, val, *ttl)
+	}
+	return gig.Set(file, key, val)
+}
+
+func del(fs *flag.FlagSet, args []string) error {
+	isHex := fs.Bool("x", false, "keys are hex")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+	file := storeName(fs.Arg(0))
+	if err := openWrite(file); err != nil {
+		return err
+	}
+	for _, arg := range fs.Args()[1:] {
+		key, err := decodeArg(arg, *isHex)
+		if err != nil {
+			return err
+		}
+		if _, err = gig.Delete(file, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys print keys of range by iterator, so keys are not copied
+func keys(fs *flag.FlagSet, args []string) error {
+	prefix := fs.String("prefix", "", "only keys with prefix")
+	from := fs.String("from", "", "start from this key")
+	limit := fs.Int("limit", 0, "print not more then limit keys (0 - all)")
+	offset := fs.Int("offset", 0, "skip offset keys")
+	desc := fs.Bool("desc", false, "descending order")
+	format := fs.String("f", "raw", "print keys as raw or hex")
+	isHex := fs.Bool("x", false, "prefix and from are hex")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	if err := checkFormat(*format, "raw", "hex"); err != nil {
+		return err
+	}
+	file := storeName(fs.Arg(0))
+	opts := &gig.IterOptions{}
+	var err error
+	if *prefix != "" {
+		if opts.Prefix, err = decodeArg(*prefix, *isHex); err != nil {
+			return err
+		}
+	}
+	if *from != "" {
+		start, err := decodeArg(*from, *isHex)
+		if err != nil {
+			return err
+		}
+		if *desc {
+			// from included
+			opts.UpperBound = append(start, 0)
+		} else {
+			opts.LowerBound = start
+		}
+	}
+	if err = openRead(file); err != nil {
+		return err
+	}
+	it, err := gig.NewIterator(file, opts)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	move := it.Next
+	if *desc {
+		move = it.Prev
+	}
+	w := bufio.NewWriter(os.Stdout)
+	skip, n := *offset, 0
+	for ok := move(); ok && (*limit <= 0 || n < *limit); ok = move() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if *format == "hex" {
+			w.WriteString(helpers.Bin2Hex(it.Key()))
+		} else {
+			w.Write(it.Key())
+		}
+		w.WriteByte('\n')
+		n++
+	}
+	return w.Flush()
+}
+
+func count(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	file := storeName(fs.Arg(0))
+	if err := openRead(file); err != nil {
+		return err
+	}
+	n, err := gig.Count(file)
+	if err != nil {
+		return err
+	}
+	fmt.Println(n)
+	return nil
+}
+
+// stat print report of gig.Check, see gigfsck for problems
+func stat(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	file := storeName(fs.Arg(0))
+	r, err := gig.Check(file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: version %d, %d keys, %d records (%d sets, %d deletes, %d batches)\n",
+		file, r.Version, r.Keys, r.Records, r.Sets, r.Deletes, r.Batches)
+	fmt.Printf("  keys %d bytes, values %d bytes, dead %d bytes\n", r.KeySize, r.ValSize, r.DeadBytes)
+	if r.Tail != nil {
+		fmt.Printf("  damaged tail at %d: %d bytes (%v)\n", r.Tail.Offset, r.Tail.Dropped, r.Tail.Err)
+	}
+	if len(r.Problems) > 0 {
+		fmt.Printf("  %d problems, see gigfsck\n", len(r.Problems))
+	}
+	return nil
+}
+
+// dump write record of every key as line of JSON
+func dump(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		return errUsage
+	}
+	file := storeName(fs.Arg(0))
+	out := os.Stdout
+	if fs.NArg() == 2 {
+		f, err := os.Create(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := openRead(file); err != nil {
+		return err
+	}
+	it, err := gig.NewIterator(file, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for key, val := range it.All() {
+		var rec record
+		if utf8.Valid(key) {
+			rec.Key = string(key)
+		} else {
+			rec.KeyHex = helpers.Bin2Hex(key)
+		}
+		if utf8.Valid(val) {
+			rec.Value = string(val)
+		} else {
+			rec.ValueHex = helpers.Bin2Hex(val)
+		}
+		ttl, err := gig.TTL(file, key)
+		if err != nil {
+			return err
+		}
+		rec.TTL = ttl.Milliseconds()
+		if err = enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	if err = it.Err(); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		return out.Sync()
+	}
+	return nil
+}
+
+// load write records of dump by batches of loadBatch keys, keys with TTL written one by one
+func load(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		return errUsage
+	}
+	file := storeName(fs.Arg(0))
+	in := os.Stdin
+	if fs.NArg() == 2 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := openWrite(file); err != nil {
+		return err
+	}
+	batch := &gig.WriteBatch{}
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		err := gig.Write(file, batch)
+		batch.Reset()
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(in))
+	n := 0
+	for line := 1; ; line++ {
+		var rec record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %v", line, err)
+		}
+		key, val := []byte(rec.Key), []byte(rec.Value)
+		if rec.KeyHex != "" {
+			key, err = hex.DecodeString(rec.KeyHex)
+		}
+		if err == nil && rec.ValueHex != "" {
+			val, err = hex.DecodeString(rec.ValueHex)
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %v", line, err)
+		}
+		if rec.TTL > 0 {
+			if err = flush(); err == nil {
+				err = gig.SetWithTTL(file, key, val, time.Duration(rec.TTL)*time.Millisecond)
+			}
+		} else {
+			batch.Put(key, val)
+			if batch.Len() >= loadBatch {
+				err = flush()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d keys loaded\n", n)
+	return nil
+}
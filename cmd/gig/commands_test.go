@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azhai/gig"
+)
+
+func TestDumpLoad(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src.db"), filepath.Join(dir, "dest.db")
+	out := filepath.Join(dir, "dump.json")
+	defer gig.CloseAll()
+	pairs := map[string][]byte{
+		"text":         []byte("value"),
+		"binary":       {0, 0xff, 0xfe},
+		"\xff\x00":     []byte("binary key"),
+		"empty":        {},
+		"unicode ключ": []byte("значение"),
+	}
+	for k, v := range pairs {
+		if err := gig.Set(src, []byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gig.SetWithTTL(src, []byte("ttl"), []byte("ttl"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// dump open store read-only
+	if err := gig.Close(src); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dump(flag.NewFlagSet("dump", flag.ContinueOnError), []string{src + gig.KEY_FILE_EXT, out}); err != nil {
+		t.Fatal(err)
+	}
+	if err := load(flag.NewFlagSet("load", flag.ContinueOnError), []string{dest, out}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := gig.Count(dest); err != nil || n != uint64(len(pairs)+1) {
+		t.Error("wrong count", n, err)
+	}
+	for k, v := range pairs {
+		if got, err := gig.Get(dest, []byte(k)); err != nil || !bytes.Equal(got, v) {
+			t.Errorf("not equal %q: %q %v", k, got, err)
+		}
+	}
+	if ttl, err := gig.TTL(dest, []byte("ttl")); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Error("ttl not loaded", ttl, err)
+	}
+}
@@ -0,0 +1,136 @@
+// gig inspect and edit gig stores
+//
+// Usage: gig [-key hex] [-wait timeout] command [flags] file [args]
+//
+// Commands:
+//
+//	get [-f raw|hex|json] [-x] file key      print value of key
+//	set [-x] [-ttl duration] file key [val]  set value of key, value read from stdin if omitted
+//	del [-x] file key ...                    delete keys
+//	keys [-prefix p] [-from k] [-limit n] [-offset n] [-desc] [-f raw|hex] [-x] file
+//	                                         print keys in order
+//	count file                               print count of keys
+//	stat file                                print version, records and sizes of files
+//	dump file [out]                          write keys and values as JSON lines
+//	load file [in]                           write keys and values from JSON lines of dump
+//
+// -x - keys and values in arguments are hex. Reads open store read-only, so they work
+// while store is written by other process, writes wait for its lock up to -wait
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/azhai/gig"
+)
+
+var (
+	keyHex   string        //AES key of sealed store
+	lockWait time.Duration //max time to wait for lock of store
+)
+
+func init() {
+	flag.StringVar(&keyHex, "key", os.Getenv("GIG_KEY"), "AES key of sealed store in hex (default $GIG_KEY)")
+	flag.DurationVar(&lockWait, "wait", 0, "wait for store locked by other process up to this time")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-key hex] [-wait timeout] command [flags] file [args]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Commands: get, set, del, keys, count, stat, dump, load (command -h for flags)")
+		flag.PrintDefaults()
+	}
+}
+
+// command - subcommand of gig, run get flags and arguments after name of command
+type command struct {
+	usage string
+	run   func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"get":   {"get [-f raw|hex|json] [-x] file key", get},
+	"set":   {"set [-x] [-ttl duration] file key [val]", set},
+	"del":   {"del [-x] file key ...", del},
+	"keys":  {"keys [-prefix p] [-from k] [-limit n] [-offset n] [-desc] [-f raw|hex] [-x] file", keys},
+	"count": {"count file", count},
+	"stat":  {"stat file", stat},
+	"dump":  {"dump file [out]", dump},
+	"load":  {"load file [in]", load},
+}
+
+// errUsage - wrong arguments, usage of command printed
+var errUsage = errors.New("wrong arguments")
+
+// storeName remove extension of keys or values file
+func storeName(file string) string {
+	file = strings.TrimSuffix(file, gig.KEY_FILE_EXT)
+	return strings.TrimSuffix(file, gig.VAL_FILE_EXT)
+}
+
+// options return options of store from global flags
+func options() (*gig.Options, error) {
+	opts := &gig.Options{LockTimeout: lockWait}
+	if keyHex != "" {
+		key, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("key: %v", err)
+		}
+		opts.Key = key
+	}
+	return opts, nil
+}
+
+// openRead open store read-only, it must exist
+func openRead(file string) error {
+	opts, err := options()
+	if err == nil {
+		_, err = gig.OpenReadOnly(file, opts)
+	}
+	return err
+}
+
+// openWrite open or create store for writing
+func openWrite(file string) error {
+	opts, err := options()
+	if err == nil {
+		_, err = gig.OpenWithOptions(file, opts)
+	}
+	return err
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n", os.Args[0], cmd.usage)
+		fs.PrintDefaults()
+	}
+	err := cmd.run(fs, flag.Args()[1:])
+	// writes synced before exit
+	if cerr := gig.CloseAll(); err == nil && cerr != nil && cerr != gig.ErrDbNotOpen {
+		err = cerr
+	}
+	if err == errUsage {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
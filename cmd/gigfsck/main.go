@@ -0,0 +1,84 @@
+// gigfsck check consistency of gig stores and write repaired copies
+//
+// Usage: gigfsck [-v] [-r dest] file ...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/azhai/gig"
+)
+
+var (
+	repairTo string //file for repaired copy
+	verbose  bool   //print every problem
+)
+
+func init() {
+	flag.StringVar(&repairTo, "r", "", "write repaired copy of store to this file")
+	flag.BoolVar(&verbose, "v", false, "print every problem")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-v] [-r dest] file ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// storeName remove extension of keys or values file
+func storeName(file string) string {
+	file = strings.TrimSuffix(file, gig.KEY_FILE_EXT)
+	return strings.TrimSuffix(file, gig.VAL_FILE_EXT)
+}
+
+func printReport(file string, r *gig.Report) {
+	fmt.Printf("%s: version %d, %d records (%d sets, %d deletes, %d batches), %d keys\n",
+		file, r.Version, r.Records, r.Sets, r.Deletes, r.Batches, r.Keys)
+	fmt.Printf("  keys %d bytes, values %d bytes, dead %d bytes\n",
+		r.KeySize, r.ValSize, r.DeadBytes)
+	if r.Tail != nil {
+		fmt.Printf("  damaged tail at %d: %d bytes (%v)\n", r.Tail.Offset, r.Tail.Dropped, r.Tail.Err)
+	}
+	if len(r.Problems) == 0 {
+		return
+	}
+	fmt.Printf("  %d problems\n", len(r.Problems))
+	if verbose {
+		for _, p := range r.Problems {
+			fmt.Printf("  at %d key %q: %v\n", p.Offset, p.Key, p.Err)
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 || repairTo != "" && flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	code := 0
+	for _, file := range flag.Args() {
+		file = storeName(file)
+		var (
+			r   *gig.Report
+			err error
+		)
+		if repairTo != "" {
+			r, err = gig.Repair(file, storeName(repairTo))
+		} else {
+			r, err = gig.Check(file)
+		}
+		if r != nil {
+			printReport(file, r)
+			if !r.Ok() {
+				code = 1
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,320 @@
+package gig
+
+import (
+	"crypto/cipher"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+type compactResponse struct {
+	reclaimed int64
+	err       error
+}
+
+type compactRequest struct {
+	upgrade bool
+	// rotate is true if values must be sealed by key, see Rotate
+	rotate       bool
+	key          []byte
+	responseChan chan compactResponse
+}
+
+// compactResult send by background copier to run goroutine
+type compactResult struct {
+	cmds map[string]*Cmd
+	err  error
+}
+
+// compaction state of store while live records copied to new files
+type compaction struct {
+	fk      *os.File
+	fv      *os.File
+	version uint8
+	dirty   map[string]struct{}
+	waiters []chan compactResponse
+	// pending upgrades and rotations wait this compaction and start new one
+	pending []compactRequest
+	done    chan compactResult
+	// rotate is true if values opened by cipher from and sealed by aead with key
+	rotate bool
+	key    []byte
+	from   cipher.AEAD
+	aead   cipher.AEAD
+}
+
+// compactDone return channel with result of compaction or nil if compaction not started
+func (s *store) compactDone() <-chan compactResult {
+	if s.compacting == nil {
+		return nil
+	}
+	return s.compacting.done
+}
+
+// startCompact create new files and copy live records in background
+// Keys changed while copying marked as dirty and copied again in finishCompact
+// If upgrade is true new files will be written in current format version
+// If rotate is true values will be sealed by new key
+func (s *store) startCompact(cr compactRequest) {
+	resp := cr.responseChan
+	version := s.version
+	if cr.upgrade {
+		version = FORMAT_VERSION
+	}
+	if s.compacting != nil {
+		if cr.upgrade && s.compacting.version != version || cr.rotate {
+			s.compacting.pending = append(s.compacting.pending, cr)
+		} else if resp != nil {
+			s.compacting.waiters = append(s.compacting.waiters, resp)
+		}
+		return
+	}
+	if cr.upgrade && s.version == FORMAT_VERSION {
+		resp <- compactResponse{}
+		return
+	}
+	c := &compaction{
+		version: version,
+		dirty:   make(map[string]struct{}),
+		done:    make(chan compactResult, 1),
+		rotate:  cr.rotate,
+		key:     s.opts.Key,
+		from:    s.aead,
+		aead:    s.aead,
+	}
+	if cr.rotate {
+		var err error
+		if s.version == 0 {
+			err = ErrNeedUpgrade
+		} else {
+			c.key = cr.key
+			c.aead, err = newCipher(cr.key)
+		}
+		if err != nil {
+			resp <- compactResponse{err: err}
+			return
+		}
+	}
+	// expired keys are not copied
+	s.sweep()
+	if s.compacting != nil {
+		// sweep may start auto compaction
+		s.startCompact(cr)
+		return
+	}
+	if resp != nil {
+		c.waiters = append(c.waiters, resp)
+	}
+	opts := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	var err error
+	// file with values created first, see recoverCompact
+	c.fv, err = os.OpenFile(s.file+VAL_FILE_EXT+COMPACT_FILE_EXT, opts, FILE_MODE)
+	if err == nil {
+		c.fk, err = os.OpenFile(s.file+KEY_FILE_EXT+COMPACT_FILE_EXT, opts, FILE_MODE)
+	}
+	if err == nil && version > 0 {
+		if err = writeHeader(c.fv, valMagic, version, s.generation+1); err == nil {
+			err = writeHeader(c.fk, keyMagic, version, s.generation+1)
+		}
+		if err == nil {
+			err = writeKeyCheck(c.fv, c.key)
+		}
+	}
+	if err != nil {
+		s.compacting = c
+		s.finishCompact(compactResult{err: err})
+		return
+	}
+
+	s.compacting = c
+	// index is never changed in place, so root is snapshot of keys
+	go c.copyLive(s.fv, s.version, s.index)
+}
+
+// copyLive copy values of keys in ascending order to new files
+func (c *compaction) copyLive(fv *os.File, version uint8, index *node) {
+	res := compactResult{cmds: make(map[string]*Cmd, index.len())}
+	index.ascend(0, func(n *node) bool {
+		cmd, err := c.copyRecord(fv, version, n.key, n.cmd)
+		if err != nil {
+			res.err = err
+			return false
+		}
+		res.cmds[string(n.key)] = cmd
+		return true
+	})
+	c.done <- res
+}
+
+// copyRecord read value from fv and write it with key to new files
+// Value copied as stored, so its codec not changed, on rotation value sealed again
+// Damaged value stop compaction, so it will not be lost silently
+func (c *compaction) copyRecord(fv *os.File, version uint8, key []byte, old *Cmd) (*Cmd, error) {
+	b, err := readStored(fv, version, old)
+	if err != nil {
+		return nil, err
+	}
+	cmd := *old
+	if c.rotate {
+		if old.Sealed {
+			if b, err = unseal(c.from, version, key, b); err != nil {
+				return nil, err
+			}
+		}
+		if cmd.Sealed = c.aead != nil; cmd.Sealed {
+			b = seal(c.aead, key, b)
+		}
+	}
+	return c.write(key, b, &cmd)
+}
+
+// write append stored value and key to new files without sync
+// Expiration, codec and seal of value are taken from old command
+func (c *compaction) write(key, val []byte, old *Cmd) (*Cmd, error) {
+	cmd := &Cmd{Size: uint32(len(val)), CRC: crc32.Checksum(val, crcTable),
+		Expire: old.Expire, Codec: old.Codec, Sealed: old.Sealed}
+	seek, _, err := writeAtPos(c.fv, val, int64(-1), false)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Seek = uint64(seek)
+	keySeek, err := writeKey(c.fk, cmd.record(c.version, key), false)
+	cmd.KeySeek = uint64(keySeek)
+	return cmd, err
+}
+
+// finishCompact swap files if copy was successful and answer to all waiters
+func (s *store) finishCompact(res compactResult) {
+	c := s.compacting
+	reclaimed, err := int64(0), res.err
+	if err == nil {
+		reclaimed, err = s.swapCompacted(c, res.cmds)
+	}
+	if err != nil && c.fk != s.fk {
+		s.removeCompacted(c)
+	}
+	s.compacting = nil
+	for _, w := range c.waiters {
+		w <- compactResponse{reclaimed: reclaimed, err: err}
+	}
+	for _, cr := range c.pending {
+		s.startCompact(cr)
+	}
+}
+
+// swapCompacted copy dirty keys, sync and rename new files over old
+func (s *store) swapCompacted(c *compaction, cmds map[string]*Cmd) (int64, error) {
+	for key := range c.dirty {
+		if cmd, exists := s.valDict[key]; exists {
+			newCmd, err := c.copyRecord(s.fv, s.version, []byte(key), cmd)
+			if err != nil {
+				return 0, err
+			}
+			cmds[key] = newCmd
+		} else if _, exists := cmds[key]; exists {
+			if _, err := writeKey(c.fk, &keyRecord{version: c.version, cmd: cmdDelete, key: []byte(key)}, false); err != nil {
+				return 0, err
+			}
+			delete(cmds, key)
+		}
+	}
+	if err := c.fv.Sync(); err != nil {
+		return 0, err
+	}
+	if err := c.fk.Sync(); err != nil {
+		return 0, err
+	}
+	// rename of values is the point of no return, see recoverCompact
+	if err := os.Rename(s.file+VAL_FILE_EXT+COMPACT_FILE_EXT, s.file+VAL_FILE_EXT); err != nil {
+		return 0, err
+	}
+	errRename := os.Rename(s.file+KEY_FILE_EXT+COMPACT_FILE_EXT, s.file+KEY_FILE_EXT)
+	syncDir(s.file)
+
+	before := s.keySize + s.valSize
+	end := s.seq(s.keySize)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fk.Close()
+	// old values may be read by views
+	s.closeVal(s.fv)
+	s.fk, s.fv = c.fk, c.fv
+	s.version = c.version
+	if c.rotate {
+		s.aead, s.opts.Key = c.aead, c.key
+	}
+	if s.version > 0 {
+		// old checkpoint describe old keys file
+		s.generation++
+	}
+	s.checkpointed = 0
+	// sizes of records and values may be changed by upgrade and rotation
+	s.liveKey, s.liveVal = 0, 0
+	for key, cmd := range cmds {
+		s.valDict[key] = cmd
+		s.index = s.index.put([]byte(key), cmd)
+		s.liveKey += cmd.keySize(s.version, []byte(key))
+		s.liveVal += int64(cmd.Size)
+	}
+	if info, err := s.fk.Stat(); err == nil {
+		s.keySize = info.Size()
+	}
+	if info, err := s.fv.Stat(); err == nil {
+		s.valSize = info.Size()
+	}
+	// new keys file has the same live keys as old one at its end
+	s.compactedSeq, s.compactedEnd = end, s.keySize
+	return before - s.keySize - s.valSize, errRename
+}
+
+// abortCompact wait background copier and remove new files
+func (s *store) abortCompact() {
+	c := s.compacting
+	<-c.done
+	s.removeCompacted(c)
+	s.compacting = nil
+	for _, cr := range c.pending {
+		c.waiters = append(c.waiters, cr.responseChan)
+	}
+	for _, w := range c.waiters {
+		w <- compactResponse{err: ErrDbNotOpen}
+	}
+}
+
+// removeCompacted close and remove new files
+func (s *store) removeCompacted(c *compaction) {
+	if c.fk != nil {
+		c.fk.Close()
+		os.Remove(s.file + KEY_FILE_EXT + COMPACT_FILE_EXT)
+	}
+	if c.fv != nil {
+		c.fv.Close()
+		os.Remove(s.file + VAL_FILE_EXT + COMPACT_FILE_EXT)
+	}
+}
+
+// recoverCompact finish or rollback compaction interrupted by crash
+// If only new keys file exists, values was already renamed and keys must be renamed too
+// Otherwise old files are untouched and new files removed
+func recoverCompact(file string) error {
+	tmpKey := file + KEY_FILE_EXT + COMPACT_FILE_EXT
+	tmpVal := file + VAL_FILE_EXT + COMPACT_FILE_EXT
+	_, errKey := os.Stat(tmpKey)
+	_, errVal := os.Stat(tmpVal)
+	if errKey == nil && os.IsNotExist(errVal) {
+		return os.Rename(tmpKey, file+KEY_FILE_EXT)
+	}
+	os.Remove(tmpKey)
+	os.Remove(tmpVal)
+	return nil
+}
+
+// syncDir sync directory of file, so renames will be durable
+func syncDir(file string) error {
+	d, err := os.Open(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,189 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	f := "tests/TestCompact.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		// bigger value will be appended to the end of file
+		ch(Set(f, k, bytes.Repeat(k, 2)), t)
+		if i%2 == 0 {
+			Delete(f, k)
+		}
+	}
+	reclaimed, err := Compact(f)
+	ch(err, t)
+	if reclaimed <= 0 {
+		t.Error("nothing reclaimed", reclaimed)
+	}
+	cnt, _ := Count(f)
+	if cnt != 50 {
+		t.Error("count after compact", cnt)
+	}
+	check := func() {
+		for i := 0; i < 100; i++ {
+			k := []byte(fmt.Sprintf("%04d", i))
+			v, err := Get(f, k)
+			if i%2 == 0 {
+				if err != ErrKeyNotFound {
+					t.Error("deleted key found", string(k))
+				}
+			} else if !bytes.Equal(v, bytes.Repeat(k, 2)) {
+				t.Error("not equal", string(k), string(v), err)
+			}
+		}
+	}
+	check()
+	Close(f)
+	check()
+
+	// nothing to reclaim second time
+	reclaimed, err = Compact(f)
+	ch(err, t)
+	if reclaimed != 0 {
+		t.Error("reclaimed on compacted store", reclaimed)
+	}
+}
+
+func TestCompactWhileWrite(t *testing.T) {
+	f := "tests/TestCompactWhileWrite.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 200; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+		ch(Set(f, k, bytes.Repeat(k, 3)), t)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			k := []byte(fmt.Sprintf("%04d", i))
+			if i%3 == 0 {
+				Delete(f, k)
+			} else {
+				ch(Set(f, k, bytes.Repeat(k, 4)), t)
+			}
+		}
+	}()
+	_, err := Compact(f)
+	ch(err, t)
+	wg.Wait()
+	Close(f)
+	for i := 0; i < 200; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		v, err := Get(f, k)
+		if i%3 == 0 {
+			if err != ErrKeyNotFound {
+				t.Error("deleted key found", string(k))
+			}
+		} else if !bytes.Equal(v, bytes.Repeat(k, 4)) {
+			t.Error("not equal", string(k), string(v), err)
+		}
+	}
+}
+
+func TestCompactWhileOverwrite(t *testing.T) {
+	f := "tests/TestCompactWhileOverwrite.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, bytes.Repeat(k, 1024)), t)
+	}
+	hot := []byte("0050")
+	for round := 0; round < 20; round++ {
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		// value of the same size, so it may be overwritten in place
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				ch(Set(f, hot, bytes.Repeat([]byte{byte('a' + i%26)}, 4096)), t)
+			}
+		}()
+		_, err := Compact(f)
+		close(done)
+		wg.Wait()
+		if err != nil {
+			t.Fatal("compaction failed in round", round, err)
+		}
+	}
+	if v, err := Get(f, hot); err != nil || len(v) != 4096 {
+		t.Error("wrong value", len(v), err)
+	}
+}
+
+func TestCompactRecover(t *testing.T) {
+	f := "tests/TestCompactRecover.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("key"), []byte("val")), t)
+	Close(f)
+
+	// both new files present - compaction was not finished, they must be removed
+	os.WriteFile(f+KEY_FILE_EXT+COMPACT_FILE_EXT, []byte("garbage"), FILE_MODE)
+	os.WriteFile(f+VAL_FILE_EXT+COMPACT_FILE_EXT, []byte("garbage"), FILE_MODE)
+	v, err := Get(f, []byte("key"))
+	if err != nil || string(v) != "val" {
+		t.Error("not recovered", string(v), err)
+	}
+	if _, err := os.Stat(f + KEY_FILE_EXT + COMPACT_FILE_EXT); !os.IsNotExist(err) {
+		t.Error("new keys file not removed")
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	f := "tests/TestAutoCompact.db"
+	DeleteFile(f)
+	ratio, minSize := AutoCompactRatio, AutoCompactMinSize
+	AutoCompactRatio, AutoCompactMinSize = 0.5, 256
+	defer func() {
+		// store goroutine must be stopped before settings restored
+		CloseAll()
+		AutoCompactRatio, AutoCompactMinSize = ratio, minSize
+	}()
+	val := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 100; i++ {
+		ch(Set(f, []byte("key"), val[:i+1]), t)
+	}
+	// without compaction values file will be 5050 bytes
+	// last compaction may be still in progress, so wait a bit
+	var size int64
+	for i := 0; i < 100; i++ {
+		info, err := os.Stat(f + VAL_FILE_EXT)
+		ch(err, t)
+		if size = info.Size(); size <= 1024 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size > 1024 {
+		t.Error("not compacted automatically", size)
+	}
+	v, _ := Get(f, []byte("key"))
+	if !bytes.Equal(v, val) {
+		t.Error("not equal")
+	}
+}
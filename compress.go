@@ -0,0 +1,104 @@
+package gig
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+)
+
+// Codec - compression of values, see Options
+// Codec of value stored in flags of key record, so values of different codecs may be mixed
+type Codec uint8
+
+const (
+	// CODEC_NONE - values stored as is (default)
+	CODEC_NONE Codec = iota
+	// CODEC_FLATE - values compressed by compress/flate
+	CODEC_FLATE
+	// CODEC_GZIP - values compressed by compress/gzip
+	CODEC_GZIP
+	// CODEC_LZ - values compressed by fast LZ codec, see lzEncode
+	CODEC_LZ
+)
+
+// DEFAULT_COMPRESS_MIN_SIZE - values smaller then this size are stored as is
+const DEFAULT_COMPRESS_MIN_SIZE = 64
+
+// codec return codec of value from flags of record
+func (rec *keyRecord) codec() Codec {
+	return Codec(rec.cmd & flagCodec >> codecShift)
+}
+
+// compress return value compressed by codec or nil if it is not smaller
+func compress(codec Codec, val []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec {
+	case CODEC_FLATE:
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case CODEC_GZIP:
+		w = gzip.NewWriter(&buf)
+	case CODEC_LZ:
+		if b := lzEncode(val); len(b) < len(val) {
+			return b
+		}
+		return nil
+	default:
+		return nil
+	}
+	w.Write(val)
+	w.Close()
+	if buf.Len() >= len(val) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// decompress return value stored by codec
+// Return ErrCorrupted if value can not be decompressed
+func decompress(codec Codec, b []byte) ([]byte, error) {
+	var r io.Reader
+	switch codec {
+	case CODEC_NONE:
+		return b, nil
+	case CODEC_FLATE:
+		fr := flate.NewReader(bytes.NewReader(b))
+		defer fr.Close()
+		r = fr
+	case CODEC_GZIP:
+		gr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, ErrCorrupted
+		}
+		defer gr.Close()
+		r = gr
+	case CODEC_LZ:
+		return lzDecode(b)
+	default:
+		return nil, ErrCorrupted
+	}
+	val, err := io.ReadAll(r)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return val, nil
+}
+
+// encodeVal return bytes of value to store and command with their size, checksum and codec
+// Value stored as is if it is small, not compressible or format has no flags
+// Value compressed first, then sealed with its key if store has key
+func (s *store) encodeVal(key, val []byte) ([]byte, *Cmd) {
+	cmd := &Cmd{}
+	if s.opts.Compression != CODEC_NONE && s.version > 0 && len(val) >= s.opts.CompressMinSize {
+		if b := compress(s.opts.Compression, val); b != nil {
+			val, cmd.Codec = b, s.opts.Compression
+		}
+	}
+	if s.aead != nil {
+		val, cmd.Sealed = seal(s.aead, key, val), true
+	}
+	cmd.Size, cmd.CRC = uint32(len(val)), crc32.Checksum(val, crcTable)
+	return val, cmd
+}
@@ -0,0 +1,97 @@
+package gig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestLZ(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	random := make([]byte, 1000)
+	r.Read(random)
+	var rows bytes.Buffer
+	for i := 0; i < 100; i++ {
+		b, _ := json.Marshal(map[string]interface{}{"id": i, "name": "device", "imei": 35000000 + i})
+		rows.Write(b)
+	}
+	for _, src := range [][]byte{nil, []byte("a"), []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), random, rows.Bytes(),
+		bytes.Repeat([]byte("abc"), 10000), append(bytes.Repeat(random[:20], 50), random...)} {
+		b := lzEncode(src)
+		d, err := lzDecode(b)
+		if err != nil || !bytes.Equal(d, src) {
+			t.Error("not equal", len(src), len(d), err)
+		}
+	}
+	if b := lzEncode(rows.Bytes()); len(b)*3 > rows.Len() {
+		t.Error("bad ratio", len(b), rows.Len())
+	}
+	// damaged data never panic
+	b := lzEncode(rows.Bytes())
+	for i := 0; i < 1000; i++ {
+		d := append([]byte{}, b...)
+		d[r.Intn(len(d))] ^= byte(1 + r.Intn(255))
+		lzDecode(d[:r.Intn(len(d))])
+		lzDecode(d)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	f := "tests/TestCompression.db"
+	DeleteFile(f)
+	defer CloseAll()
+	row := bytes.Repeat([]byte(`{"id":1,"name":"device"}`), 20)
+	ch(Set(f, []byte("raw"), row), t)
+	Close(f)
+	for _, codec := range []Codec{CODEC_FLATE, CODEC_GZIP, CODEC_LZ} {
+		db, err := OpenWithOptions(f, &Options{Compression: codec, CompressMinSize: 100})
+		ch(err, t)
+		k := []byte(fmt.Sprint("codec", codec))
+		ch(Set(f, k, row), t)
+		if cmd := db.s.valDict[string(k)]; cmd.Codec != codec || int(cmd.Size) >= len(row)/2 {
+			t.Error("value not compressed", codec, cmd.Codec, cmd.Size)
+		}
+		ch(Set(f, []byte("small"), []byte("small value")), t)
+		if cmd := db.s.valDict["small"]; cmd.Codec != CODEC_NONE {
+			t.Error("small value compressed", cmd.Codec)
+		}
+		batch := &WriteBatch{}
+		batch.Put([]byte(fmt.Sprint("batch", codec)), row)
+		ch(Write(f, batch), t)
+		Close(f)
+	}
+	check := func() {
+		for _, k := range []string{"raw", "codec1", "codec2", "codec3", "batch1", "batch2", "batch3"} {
+			if v, err := Get(f, []byte(k)); err != nil || !bytes.Equal(v, row) {
+				t.Error("not equal", k, len(v), err)
+			}
+		}
+	}
+	// mixed records read by store without compression
+	check()
+	ch(Checkpoint(f), t)
+	_, err := Compact(f)
+	ch(err, t)
+	check()
+	Close(f)
+	check()
+	r, err := Check(f)
+	ch(err, t)
+	if len(r.Problems) > 0 {
+		t.Error("problems found", r.Problems)
+	}
+}
+
+func BenchmarkCompressLZ(b *testing.B) {
+	var rows bytes.Buffer
+	for i := 0; i < 100; i++ {
+		b, _ := json.Marshal(map[string]interface{}{"id": i, "name": "device", "imei": 35000000 + i})
+		rows.Write(b)
+	}
+	b.SetBytes(int64(rows.Len()))
+	for i := 0; i < b.N; i++ {
+		lzEncode(rows.Bytes())
+	}
+}
@@ -0,0 +1,125 @@
+package gig
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+)
+
+// Values of store opened with key are sealed by AES-GCM, stored value is nonce followed by sealed bytes
+// Sealed value has flagSealed in key record, so sealed and plain values may be mixed
+// Header of values file has check of key, so store opened with wrong key return ErrWrongKey
+
+// keyCheckSeek - offset of key check in header of values file
+const keyCheckSeek = 16
+
+// keyCheck return check of key stored in header, it is not enough to find key
+func keyCheck(key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("gig key check"))
+	return h.Sum(nil)[:HEADER_SIZE-keyCheckSeek]
+}
+
+// newCipher return AES-GCM cipher of key, nil key - values not sealed
+func newCipher(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readKeyCheck return check of key from header of values file, nil if store has no key
+func readKeyCheck(fv *os.File) ([]byte, error) {
+	b := make([]byte, HEADER_SIZE-keyCheckSeek)
+	if _, err := fv.ReadAt(b, keyCheckSeek); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, make([]byte, len(b))) {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// writeKeyCheck store check of key in header of values file, nil key remove check
+func writeKeyCheck(fv *os.File, key []byte) error {
+	check := make([]byte, HEADER_SIZE-keyCheckSeek)
+	if key != nil {
+		check = keyCheck(key)
+	}
+	_, err := fv.WriteAt(check, keyCheckSeek)
+	return err
+}
+
+// openCipher check key with header of values file and return cipher of values
+// Key of store without key is stored in header on first open with key, if store is not read-only
+func openCipher(fv *os.File, version uint8, key []byte, readOnly bool) (cipher.AEAD, error) {
+	if version == 0 {
+		if key != nil {
+			return nil, ErrNeedUpgrade
+		}
+		return nil, nil
+	}
+	check, err := readKeyCheck(fv)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if check != nil {
+			return nil, ErrWrongKey
+		}
+		return nil, nil
+	}
+	aead, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if check == nil {
+		if readOnly {
+			return aead, nil
+		}
+		if err = writeKeyCheck(fv, key); err == nil {
+			err = fv.Sync()
+		}
+		return aead, err
+	}
+	if !hmac.Equal(check, keyCheck(key)) {
+		return nil, ErrWrongKey
+	}
+	return aead, nil
+}
+
+// seal return nonce and sealed value
+// Key is authenticated with value, so value moved to other key can not be opened
+func seal(aead cipher.AEAD, key, val []byte) []byte {
+	b := make([]byte, aead.NonceSize(), aead.NonceSize()+len(val)+aead.Overhead())
+	rand.Read(b)
+	return aead.Seal(b, b, val, key)
+}
+
+// unseal return value sealed by seal
+// Checksum of value checked before, so if value can not be opened key is wrong,
+// in format without checksums value may be damaged too
+func unseal(aead cipher.AEAD, version uint8, key, b []byte) ([]byte, error) {
+	if aead == nil {
+		return nil, ErrWrongKey
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	val, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], key)
+	if err != nil {
+		if version < 2 {
+			return nil, ErrCorrupted
+		}
+		return nil, ErrWrongKey
+	}
+	return val, nil
+}
@@ -0,0 +1,108 @@
+package gig
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	f := "tests/TestEncryption.db"
+	DeleteFile(f)
+	defer CloseAll()
+	key := bytes.Repeat([]byte("k"), 32)
+	secret := []byte("auth code 123456")
+	ch(Set(f, []byte("plain"), []byte("plain value")), t)
+	Close(f)
+	_, err := OpenWithOptions(f, &Options{Key: key, Compression: CODEC_LZ, CompressMinSize: 1})
+	ch(err, t)
+	ch(Set(f, []byte("secret"), secret), t)
+	ch(Set(f, []byte("row"), bytes.Repeat(secret, 10)), t)
+	batch := &WriteBatch{}
+	batch.Put([]byte("batch"), secret)
+	ch(Write(f, batch), t)
+	Close(f)
+	b, err := os.ReadFile(f + VAL_FILE_EXT)
+	ch(err, t)
+	if bytes.Contains(b, []byte("auth code")) {
+		t.Error("value not sealed")
+	}
+
+	check := func(key []byte) {
+		_, err := OpenWithOptions(f, &Options{Key: key})
+		ch(err, t)
+		for k, val := range map[string][]byte{"plain": []byte("plain value"), "secret": secret,
+			"row": bytes.Repeat(secret, 10), "batch": secret} {
+			if b, err := Get(f, []byte(k)); err != nil || !bytes.Equal(b, val) {
+				t.Error("not equal", k, string(b), err)
+			}
+		}
+	}
+	check(key)
+	Close(f)
+	if _, err = OpenWithOptions(f, &Options{Key: bytes.Repeat([]byte("x"), 32)}); err != ErrWrongKey {
+		t.Error("opened with wrong key", err)
+	}
+	if _, err = Open(f); err != ErrWrongKey {
+		t.Error("opened without key", err)
+	}
+
+	// rotation
+	newKey := bytes.Repeat([]byte("n"), 16)
+	_, err = OpenWithOptions(f, &Options{Key: key})
+	ch(err, t)
+	v, err := Snapshot(f)
+	ch(err, t)
+	defer v.Release()
+	ch(Rotate(f, newKey), t)
+	if b, err := v.Get([]byte("secret")); err != nil || !bytes.Equal(b, secret) {
+		t.Error("view not read after rotation", string(b), err)
+	}
+	if b, err := Get(f, []byte("plain")); err != nil || string(b) != "plain value" {
+		t.Error("not equal", string(b), err)
+	}
+	if _, err = OpenWithOptions(f, &Options{Key: newKey}); err != nil {
+		t.Error("options not changed by rotation", err)
+	}
+	Close(f)
+	if _, err = OpenWithOptions(f, &Options{Key: key}); err != ErrWrongKey {
+		t.Error("opened with old key", err)
+	}
+	check(newKey)
+	b, _ = os.ReadFile(f + VAL_FILE_EXT)
+	if bytes.Contains(b, []byte("plain value")) {
+		t.Error("value not sealed by rotation")
+	}
+	if r, err := Check(f); err != nil || len(r.Problems) > 0 {
+		t.Error("problems found", r, err)
+	}
+
+	// rotation to nil key remove seal
+	ch(Rotate(f, nil), t)
+	// sizes of values are changed by rotation
+	if db, _ := Open(f); db.s.deadBytes() != 0 {
+		t.Error("wrong dead bytes after rotation", db.s.deadBytes())
+	}
+	Close(f)
+	check(nil)
+}
+
+func TestSealedMoved(t *testing.T) {
+	f := "tests/TestSealedMoved.db"
+	DeleteFile(f)
+	defer CloseAll()
+	db, err := OpenWithOptions(f, &Options{Key: bytes.Repeat([]byte("k"), 32)})
+	ch(err, t)
+	ch(Set(f, []byte("a"), []byte("value of a")), t)
+	ch(Set(f, []byte("b"), []byte("value of b")), t)
+	// value of b moved to key a like by edit of keys file
+	db.s.mu.Lock()
+	db.s.valDict["a"] = db.s.valDict["b"]
+	db.s.mu.Unlock()
+	if b, err := Get(f, []byte("a")); err != ErrWrongKey {
+		t.Error("value of other key opened", string(b), err)
+	}
+	if b, err := Get(f, []byte("b")); err != nil || string(b) != "value of b" {
+		t.Error("not equal", string(b), err)
+	}
+}
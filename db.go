@@ -6,7 +6,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
-	"runtime"
+	"sync"
 )
 
 // checkAndCreate may create dirs
@@ -25,16 +25,6 @@ func checkAndCreate(path string) (bool, error) {
 	return false, err
 }
 
-type readResponse struct {
-	val []byte
-	err error
-}
-
-type readRequest struct {
-	readKey      string
-	responseChan chan readResponse
-}
-
 type writeResponse struct {
 	err error
 }
@@ -42,12 +32,23 @@ type writeResponse struct {
 type writeRequest struct {
 	readKey      string
 	writeVal     []byte
+	expire       int64
 	responseChan chan writeResponse
 }
 
 type deleteRequest struct {
 	deleteKey    string
-	responseChan chan struct{}
+	responseChan chan error
+}
+
+type deleteRangeResponse struct {
+	deleted int
+	err     error
+}
+
+type deleteRangeRequest struct {
+	start, end   []byte
+	responseChan chan deleteRangeResponse
 }
 
 type keysResponse struct {
@@ -71,24 +72,6 @@ type setsRequest struct {
 	responseChan chan setsResponse
 }
 
-type getsResponse struct {
-	pairs [][]byte
-}
-
-type getsRequest struct {
-	keys         [][]byte
-	responseChan chan getsResponse
-}
-
-type hasResponse struct {
-	exists bool
-}
-
-type hasRequest struct {
-	key          string
-	responseChan chan hasResponse
-}
-
 type counterGetResponse struct {
 	counter uint64
 }
@@ -106,22 +89,108 @@ type counterSetRequest struct {
 }
 
 // DB store channels with requests
+// Reads served in caller goroutine under shared lock of store
+// DB is handle of opened store, it is valid until Close
 type DB struct {
-	readRequests       chan readRequest
+	s                  *store
 	writeRequests      chan writeRequest
 	deleteRequests     chan deleteRequest
+	rangeRequests      chan deleteRangeRequest
 	keysRequests       chan keysRequest
 	setsRequests       chan setsRequest
-	getsRequests       chan getsRequest
-	hasRequests        chan hasRequest
 	counterGetRequests chan counterGetRequest
 	counterSetRequests chan counterSetRequest
+	compactRequests    chan compactRequest
+	expireRequests     chan expireRequest
+	batchRequests      chan batchRequest
+	snapshotRequests   chan snapshotRequest
+	checkpointRequests chan chan error
+	syncRequests       chan chan error
+	refreshRequests    chan chan error
+	changesRequests    chan changesRequest
+	// recovery set on open, see Recovery
+	recovery *Recovery
+	// mu guard closing, calls of store goroutine in progress counted by inflight
+	mu       sync.RWMutex
+	closing  bool
+	inflight sync.WaitGroup
+	cancel   context.CancelFunc
+	// done closed when store goroutine exit
+	done chan struct{}
+}
+
+// readOnly return true if store is not written by callers, see OpenReadOnly and Follow
+func (db *DB) readOnly() bool {
+	return db.s.readOnly || db.s.opts.replica
+}
+
+// enter register call of store goroutine, return false if DB closed
+// Close wait all registered calls, so store goroutine answer them
+func (db *DB) enter() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closing {
+		return false
+	}
+	db.inflight.Add(1)
+	return true
+}
+
+// leave finish call registered by enter
+func (db *DB) leave() {
+	db.inflight.Done()
+}
+
+// Close wait calls in progress, stop store goroutine, sync and close files
+// After Close all calls of DB return ErrDbNotOpen, use Open to get new DB
+// Store can not be opened again until Close return
+func (db *DB) Close() error {
+	// calls in progress may open other stores, so they are waited without mutex
+	mutex.Lock()
+	if stores[db.s.file] == db {
+		delete(stores, db.s.file)
+	}
+	db.mu.Lock()
+	if db.closing {
+		db.mu.Unlock()
+		mutex.Unlock()
+		return ErrDbNotOpen
+	}
+	db.closing = true
+	db.mu.Unlock()
+	closing[db.s.file] = db
+	mutex.Unlock()
+	db.inflight.Wait()
+	db.cancel()
+	<-db.done
+	mutex.Lock()
+	if closing[db.s.file] == db {
+		delete(closing, db.s.file)
+	}
+	mutex.Unlock()
+	return db.s.closeErr
+}
+
+// closed return true if DB closed
+func (db *DB) closed() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.closing
 }
 
-// internal set
-func (db *DB) setKey(key string, val []byte) error {
+// internal set, expire - unix time in milliseconds or 0
+func (db *DB) setKey(key string, val []byte, expire int64) error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.readOnly() {
+		return ErrReadOnly
+	}
+	db.s.writers.Add(1)
+	defer db.s.writers.Add(-1)
 	c := make(chan writeResponse)
-	w := writeRequest{readKey: key, writeVal: val, responseChan: c}
+	w := writeRequest{readKey: key, writeVal: val, expire: expire, responseChan: c}
 	db.writeRequests <- w
 	resp := <-c
 	return resp.err
@@ -129,32 +198,65 @@ func (db *DB) setKey(key string, val []byte) error {
 
 // internal get
 func (db *DB) readKey(key string) ([]byte, error) {
-	c := make(chan readResponse)
-	w := readRequest{readKey: key, responseChan: c}
-	db.readRequests <- w
-	resp := <-c
-	return resp.val, resp.err
+	return db.s.get(key)
 }
 
 // internal delete
-func (db *DB) deleteKey(key string) {
-	c := make(chan struct{})
+func (db *DB) deleteKey(key string) error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.readOnly() {
+		return ErrReadOnly
+	}
+	c := make(chan error)
 	d := deleteRequest{deleteKey: key, responseChan: c}
 	db.deleteRequests <- d
-	<-c
+	return <-c
+}
+
+// internal delete of range
+func (db *DB) deleteRange(start, end []byte) (int, error) {
+	if !db.enter() {
+		return 0, ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.readOnly() {
+		return 0, ErrReadOnly
+	}
+	c := make(chan deleteRangeResponse)
+	db.rangeRequests <- deleteRangeRequest{start: start, end: end, responseChan: c}
+	resp := <-c
+	return resp.deleted, resp.err
 }
 
 // internal keys
-func (db *DB) readKeys(from []byte, limit, offset uint32, asc bool) [][]byte {
+// If expired keys are not swept yet, store goroutine sweep them first
+func (db *DB) readKeys(from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	if !db.enter() {
+		return make([][]byte, 0), ErrDbNotOpen
+	}
+	defer db.leave()
+	if keys, ok := db.s.keys(from, limit, offset, asc); ok {
+		return keys, nil
+	}
 	c := make(chan keysResponse)
 	w := keysRequest{responseChan: c, fromKey: from, limit: limit, offset: offset, asc: asc}
 	db.keysRequests <- w
 	resp := <-c
-	return resp.keys
+	return resp.keys, nil
 }
 
 // internal sets
 func (db *DB) sets(setPairs [][]byte) error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.readOnly() {
+		return ErrReadOnly
+	}
 	c := make(chan setsResponse)
 	w := setsRequest{pairs: setPairs, responseChan: c}
 	db.setsRequests <- w
@@ -164,24 +266,20 @@ func (db *DB) sets(setPairs [][]byte) error {
 
 // internal gets
 func (db *DB) gets(keys [][]byte) [][]byte {
-	c := make(chan getsResponse)
-	w := getsRequest{keys: keys, responseChan: c}
-	db.getsRequests <- w
-	resp := <-c
-	return resp.pairs
+	return db.s.gets(keys)
 }
 
 // internal has
-func (db *DB) has(key string) bool {
-	c := make(chan hasResponse)
-	w := hasRequest{key: key, responseChan: c}
-	db.hasRequests <- w
-	resp := <-c
-	return resp.exists
+func (db *DB) has(key string) (bool, error) {
+	return db.s.has(key)
 }
 
 // internal counter
 func (db *DB) counterGet(key string) uint64 {
+	if !db.enter() {
+		return 0
+	}
+	defer db.leave()
 	c := make(chan counterGetResponse)
 	w := counterGetRequest{key: key, responseChan: c}
 	db.counterGetRequests <- w
@@ -191,70 +289,283 @@ func (db *DB) counterGet(key string) uint64 {
 
 // internal counter
 func (db *DB) counterSet(key string, counterNewVal uint64, store bool) {
+	if db.readOnly() || !db.enter() {
+		return
+	}
+	defer db.leave()
 	c := make(chan struct{})
 	w := counterSetRequest{key: key, counter: counterNewVal, store: store, responseChan: c}
 	db.counterSetRequests <- w
 	<-c
 }
 
+// internal compact
+func (db *DB) compact(w compactRequest) (int64, error) {
+	if !db.enter() {
+		return 0, ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.s.readOnly {
+		return 0, ErrReadOnly
+	}
+	c := make(chan compactResponse, 1)
+	w.responseChan = c
+	db.compactRequests <- w
+	resp := <-c
+	return resp.reclaimed, resp.err
+}
+
+// internal expire, if set is false only return expiration time
+func (db *DB) expire(key string, expire int64, set bool) (int64, error) {
+	if !db.enter() {
+		return 0, ErrDbNotOpen
+	}
+	defer db.leave()
+	if set && db.readOnly() {
+		return 0, ErrReadOnly
+	}
+	c := make(chan expireResponse)
+	w := expireRequest{key: key, expire: expire, set: set, responseChan: c}
+	db.expireRequests <- w
+	resp := <-c
+	return resp.expire, resp.err
+}
+
+// internal batch
+func (db *DB) writeBatch(ops []batchOp) error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.readOnly() {
+		return ErrReadOnly
+	}
+	c := make(chan writeResponse)
+	w := batchRequest{ops: ops, responseChan: c}
+	db.batchRequests <- w
+	resp := <-c
+	return resp.err
+}
+
+// internal batch of Follower, it is written to replica
+func (db *DB) replicate(ops []batchOp) error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	c := make(chan writeResponse)
+	db.batchRequests <- batchRequest{ops: ops, responseChan: c}
+	resp := <-c
+	return resp.err
+}
+
+// internal snapshot
+func (db *DB) snapshot() (*View, error) {
+	if !db.enter() {
+		return nil, ErrDbNotOpen
+	}
+	defer db.leave()
+	c := make(chan *View)
+	db.snapshotRequests <- snapshotRequest{responseChan: c}
+	v := <-c
+	v.requests = db.snapshotRequests
+	return v, nil
+}
+
+// internal checkpoint
+func (db *DB) checkpoint() error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	if db.s.readOnly {
+		return ErrReadOnly
+	}
+	c := make(chan error)
+	db.checkpointRequests <- c
+	return <-c
+}
+
+// internal sync
+func (db *DB) sync() error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	c := make(chan error)
+	db.syncRequests <- c
+	return <-c
+}
+
+// internal refresh
+func (db *DB) refresh() error {
+	if !db.enter() {
+		return ErrDbNotOpen
+	}
+	defer db.leave()
+	c := make(chan error)
+	db.refreshRequests <- c
+	return <-c
+}
+
+// internal watch
+func (db *DB) watch(prefix []byte) (<-chan Event, func(), error) {
+	if !db.enter() {
+		return nil, nil, ErrDbNotOpen
+	}
+	defer db.leave()
+	events, cancel := db.s.watch(prefix)
+	return events, cancel, nil
+}
+
+// internal changes, return changes and sequence number of last change
+func (db *DB) changes(seq uint64, limit int) ([]Change, uint64, error) {
+	if !db.enter() {
+		return nil, 0, ErrDbNotOpen
+	}
+	defer db.leave()
+	c := make(chan changesResponse)
+	db.changesRequests <- changesRequest{seq: seq, limit: limit, responseChan: c}
+	resp := <-c
+	return resp.changes, resp.seq, resp.err
+}
+
 // internal counter
-func (db *DB) countKeys() uint64 {
-	return db.counterGet(NAME_COUNT_KEYS)
+func (db *DB) countKeys() (uint64, error) {
+	if !db.enter() {
+		return 0, ErrDbNotOpen
+	}
+	defer db.leave()
+	if cnt, ok := db.s.count(); ok {
+		return cnt, nil
+	}
+	c := make(chan counterGetResponse)
+	db.counterGetRequests <- counterGetRequest{key: NAME_COUNT_KEYS, responseChan: c}
+	return (<-c).counter, nil
+}
+
+// open lock store and open its files for writing
+// Files will be created (with dirs) if not exist
+func (s *store) open() (err error) {
+	exists, err := checkAndCreate(s.file)
+	if exists && err != nil {
+		return err
+	}
+	// files of store may be changed only by process which locked it
+	if s.fl, err = lockStore(s.file+LOCK_FILE_EXT, false, s.opts.LockTimeout); err != nil {
+		return err
+	}
+	// readers of other processes found by lock of this file, see shared
+	if s.fr, err = os.OpenFile(s.file+READ_LOCK_FILE_EXT, os.O_CREATE|os.O_RDWR, FILE_MODE); err != nil {
+		return err
+	}
+	if err = recoverCompact(s.file); err != nil {
+		return err
+	}
+	flags := os.O_CREATE | os.O_RDWR
+	//files
+	if s.fk, err = os.OpenFile(s.file+KEY_FILE_EXT, flags, FILE_MODE); err != nil {
+		return err
+	}
+	//fk, err := syncfile.NewSyncFile(file+KEY_FILE_EXT, FILE_MODE)
+	if s.fv, err = os.OpenFile(s.file+VAL_FILE_EXT, flags, FILE_MODE); err != nil {
+		return err
+	}
+	if s.version, err = openVersion(s.fk, s.fv); err != nil {
+		return err
+	}
+	if s.version > 0 {
+		if s.generation, err = readGeneration(s.fk); err != nil {
+			return err
+		}
+	}
+	s.aead, err = openCipher(s.fv, s.version, s.opts.Key, false)
+	return err
+}
+
+// closeFiles close opened files and release lock of store
+func (s *store) closeFiles() error {
+	var err error
+	for _, f := range []*os.File{s.fk, s.fv, s.fr} {
+		if f == nil {
+			continue
+		}
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+	}
+	if errClose := unlockStore(s.fl); err == nil {
+		err = errClose
+	}
+	return err
 }
 
 // newDB Create new DB
 // it return error if any
-// DB has finalizer for canceling goroutine
 // File will be created (with dirs) if not exist
-func newDB(file string) (*DB, error) {
+func newDB(file string, opts Options) (*DB, error) {
 	ctx, cancel := context.WithCancel(context.Background())
-	readRequests := make(chan readRequest)
 	writeRequests := make(chan writeRequest)
 	deleteRequests := make(chan deleteRequest)
+	rangeRequests := make(chan deleteRangeRequest)
 	keysRequests := make(chan keysRequest)
 	setsRequests := make(chan setsRequest)
-	getsRequests := make(chan getsRequest)
-	hasRequests := make(chan hasRequest)
 	counterGetRequests := make(chan counterGetRequest)
 	counterSetRequests := make(chan counterSetRequest)
+	compactRequests := make(chan compactRequest)
+	expireRequests := make(chan expireRequest)
+	batchRequests := make(chan batchRequest)
+	snapshotRequests := make(chan snapshotRequest)
+	checkpointRequests := make(chan chan error)
+	syncRequests := make(chan chan error)
+	refreshRequests := make(chan chan error)
+	changesRequests := make(chan changesRequest)
 	d := &DB{
-		readRequests:       readRequests,
 		writeRequests:      writeRequests,
 		deleteRequests:     deleteRequests,
+		rangeRequests:      rangeRequests,
 		keysRequests:       keysRequests,
 		setsRequests:       setsRequests,
-		getsRequests:       getsRequests,
-		hasRequests:        hasRequests,
 		counterGetRequests: counterGetRequests,
 		counterSetRequests: counterSetRequests,
+		compactRequests:    compactRequests,
+		expireRequests:     expireRequests,
+		batchRequests:      batchRequests,
+		snapshotRequests:   snapshotRequests,
+		checkpointRequests: checkpointRequests,
+		syncRequests:       syncRequests,
+		refreshRequests:    refreshRequests,
+		changesRequests:    changesRequests,
+		cancel:             cancel,
+		done:               make(chan struct{}),
 	}
-	// This is a lambda, so we don't have to add members to the struct
-	runtime.SetFinalizer(d, func(db *DB) {
-		cancel()
-	})
-
-	exists, err := checkAndCreate(file)
-	if exists && err != nil {
-		cancel()
-		return nil, err
+	s := &store{file: file, opts: opts, readOnly: opts.readOnly}
+	var err error
+	if opts.readOnly {
+		err = s.openReadOnly()
+	} else {
+		err = s.open()
 	}
-	opts := os.O_CREATE | os.O_RDWR
-	//files
-	fk, err := os.OpenFile(file+KEY_FILE_EXT, opts, FILE_MODE)
-	//fk, err := syncfile.NewSyncFile(file+KEY_FILE_EXT, FILE_MODE)
-	if err != nil {
-		cancel()
-		return nil, err
+	//read keys
+	if err == nil {
+		err = s.replay()
 	}
-	fv, err := os.OpenFile(file+VAL_FILE_EXT, opts, FILE_MODE)
 	if err != nil {
+		s.closeFiles()
 		cancel()
 		return nil, err
 	}
+	d.recovery = s.recovery
 
 	// We can't have run be a method of DB, because otherwise then the goroutine will keep the reference alive
-	go run(ctx, fk, fv, readRequests, writeRequests, deleteRequests, keysRequests, setsRequests, getsRequests,
-		hasRequests, counterGetRequests, counterSetRequests)
+	d.s = s
+	done := d.done
+	go func() {
+		run(ctx, s, writeRequests, deleteRequests, rangeRequests, keysRequests, setsRequests,
+			counterGetRequests, counterSetRequests, compactRequests, expireRequests, batchRequests, snapshotRequests,
+			checkpointRequests, syncRequests, refreshRequests, changesRequests)
+		close(done)
+	}()
 
 	return d, nil
 }
@@ -0,0 +1,263 @@
+package gig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Format of files
+//
+// Version 0 files has no header, key record is:
+// version(1) cmd(1) seek(4) size(4) time(4) keySize(2) key
+//
+// Version 1 files starts with header of HEADER_SIZE bytes:
+// magic(4) version(1) reserved(3) generation(8) reserved(16)
+// Generation is increased by compaction, it bind checkpoint to keys file
+// and key record is:
+// version(1) cmd(1) seek(8) size(4) time(4) keySize(4) key
+//
+// Version 2 key record has checksums of value and of record itself:
+// version(1) cmd(1) seek(8) size(4) time(4) keySize(4) key valCRC(4) CRC(4)
+//
+// Since version 1 high bits of cmd are flags, they add fields after key:
+// flagExpire - expire(8) unix time in milliseconds
+// Bits of flagCodec has codec of value, see Codec
+// flagSealed - value sealed by key of store, see openCipher
+//
+// Records of write batch has flagBatch and followed by commit record
+// with count of records in size. Batch without commit is discarded on replay
+//
+// Range delete record has start and end of range in key and size of start in size,
+// it delete keys from start to end, end excluded, empty end - to last key
+const (
+	// FORMAT_VERSION - version of format for new files
+	FORMAT_VERSION = 2
+	// HEADER_SIZE - size of header in files of version 1 and above
+	HEADER_SIZE = 32
+
+	cmdSet    = 0
+	cmdDelete = 1
+	cmdCommit = 2
+	// cmdDeleteRange - delete keys of range, see delRange
+	cmdDeleteRange = 3
+	// cmdMask - command in low bits of cmd
+	cmdMask = 0x07
+	// flagExpire - record has expiration time
+	flagExpire = 0x08
+	// flagBatch - record is part of write batch
+	flagBatch = 0x10
+	// flagCodec - codec of value, see Codec
+	flagCodec  = 0x60
+	codecShift = 5
+	// flagSealed - value sealed by key of store
+	flagSealed = 0x80
+)
+
+var (
+	keyMagic = []byte("GIGK")
+	valMagic = []byte("GIGV")
+
+	errTruncated   = errors.New("Error: truncated record")
+	errChecksum    = errors.New("Error: checksum mismatch")
+	errUncommitted = errors.New("Error: uncommitted batch")
+	errVersion     = errors.New("Error: record of other version")
+
+	// crcTable used for checksums of values and key records
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// keyRecord is record of keys file
+type keyRecord struct {
+	version uint8
+	cmd     uint8
+	seek    uint64
+	size    uint32
+	time    uint32
+	crc     uint32
+	expire  int64
+	key     []byte
+}
+
+// keyRecordSize return size of key record on disk
+func keyRecordSize(version uint8, t uint8, key []byte) int64 {
+	var size int64
+	switch version {
+	case 0:
+		return int64(16 + len(key))
+	case 1:
+		size = int64(22 + len(key))
+	default:
+		size = int64(30 + len(key))
+	}
+	if t&flagExpire != 0 {
+		size += 8
+	}
+	return size
+}
+
+// encodeKey append key record to buffer
+// Version 0 can not address values after 4GB, keys longer 64KB and has no flags
+// Checksum of value stored since version 2
+func encodeKey(buf *bytes.Buffer, rec *keyRecord) error {
+	start := buf.Len()
+	if rec.version == 0 {
+		if rec.seek > math.MaxUint32 || len(rec.key) > math.MaxUint16 || rec.cmd&^cmdMask != 0 {
+			return ErrNeedUpgrade
+		}
+	}
+	binary.Write(buf, binary.BigEndian, rec.version) //1byte version
+	binary.Write(buf, binary.BigEndian, rec.cmd)     //1byte command code(0-set,1-delete) with flags
+	if rec.version == 0 {
+		binary.Write(buf, binary.BigEndian, uint32(rec.seek)) //4byte seek
+	} else {
+		binary.Write(buf, binary.BigEndian, rec.seek) //8byte seek
+	}
+	binary.Write(buf, binary.BigEndian, rec.size)                  //4byte size
+	binary.Write(buf, binary.BigEndian, uint32(time.Now().Unix())) //4byte timestamp
+	if rec.version == 0 {
+		binary.Write(buf, binary.BigEndian, uint16(len(rec.key))) //2byte key size
+	} else {
+		binary.Write(buf, binary.BigEndian, uint32(len(rec.key))) //4byte key size
+	}
+	buf.Write(rec.key) //key
+	if rec.cmd&flagExpire != 0 {
+		binary.Write(buf, binary.BigEndian, rec.expire) //8byte expiration time
+	}
+	if rec.version >= 2 {
+		binary.Write(buf, binary.BigEndian, rec.crc) //4byte value checksum
+		//4byte record checksum
+		binary.Write(buf, binary.BigEndian, crc32.Checksum(buf.Bytes()[start:], crcTable))
+	}
+	return nil
+}
+
+// decodeKey read key record from b
+// return record and count of bytes used by record
+func decodeKey(b []byte) (rec keyRecord, n int, err error) {
+	if len(b) < 2 {
+		return rec, 0, errTruncated
+	}
+	rec.version, rec.cmd = b[0], b[1]
+	var keySize int
+	switch rec.version {
+	case 0:
+		if len(b) < 16 {
+			return rec, 0, errTruncated
+		}
+		rec.seek = uint64(binary.BigEndian.Uint32(b[2:]))
+		rec.size = binary.BigEndian.Uint32(b[6:])
+		rec.time = binary.BigEndian.Uint32(b[10:])
+		keySize = int(binary.BigEndian.Uint16(b[14:]))
+		n = 16 + keySize
+		if n > len(b) {
+			return rec, 0, errTruncated
+		}
+		rec.key = b[16:n]
+		return rec, n, nil
+	case 1, 2:
+		if len(b) < 22 {
+			return rec, 0, errTruncated
+		}
+		rec.seek = binary.BigEndian.Uint64(b[2:])
+		rec.size = binary.BigEndian.Uint32(b[10:])
+		rec.time = binary.BigEndian.Uint32(b[14:])
+		keySize = int(binary.BigEndian.Uint32(b[18:]))
+	default:
+		return rec, 0, ErrUnknownFormat
+	}
+	n = int(keyRecordSize(rec.version, rec.cmd, nil)) + keySize
+	if keySize < 0 || n > len(b) {
+		return rec, 0, errTruncated
+	}
+	if rec.version >= 2 {
+		if crc32.Checksum(b[:n-4], crcTable) != binary.BigEndian.Uint32(b[n-4:]) {
+			return rec, 0, errChecksum
+		}
+		rec.crc = binary.BigEndian.Uint32(b[n-8:])
+	}
+	rec.key = b[22 : 22+keySize]
+	if rec.cmd&flagExpire != 0 {
+		rec.expire = int64(binary.BigEndian.Uint64(b[22+keySize:]))
+	}
+	return rec, n, nil
+}
+
+// writeHeader write header with magic, version and generation at the start of empty file
+func writeHeader(f *os.File, magic []byte, version uint8, generation uint64) error {
+	header := make([]byte, HEADER_SIZE)
+	copy(header, magic)
+	header[len(magic)] = version
+	binary.BigEndian.PutUint64(header[8:], generation)
+	_, err := f.WriteAt(header, 0)
+	return err
+}
+
+// readGeneration return generation from header of file
+func readGeneration(f *os.File) (uint64, error) {
+	b := make([]byte, 8)
+	if _, err := f.ReadAt(b, 8); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// readHeader return version of file and size of header
+// Files without header are files of version 0
+func readHeader(f *os.File, magic []byte) (version uint8, size int64, err error) {
+	header := make([]byte, HEADER_SIZE)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	if n < len(magic) || !bytes.Equal(header[:len(magic)], magic) {
+		return 0, 0, nil
+	}
+	if n < HEADER_SIZE {
+		return 0, 0, ErrUnknownFormat
+	}
+	version = header[len(magic)]
+	if version == 0 || version > FORMAT_VERSION {
+		return version, 0, ErrUnknownFormat
+	}
+	return version, HEADER_SIZE, nil
+}
+
+// openVersion detect version of opened files
+// Headers written to new files, so they will have current version
+func openVersion(fk, fv *os.File) (version uint8, err error) {
+	infoKey, err := fk.Stat()
+	if err != nil {
+		return 0, err
+	}
+	infoVal, err := fv.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if infoKey.Size() == 0 && infoVal.Size() == 0 {
+		if err = writeHeader(fv, valMagic, FORMAT_VERSION, 0); err == nil {
+			err = writeHeader(fk, keyMagic, FORMAT_VERSION, 0)
+		}
+		return FORMAT_VERSION, err
+	}
+	return detectVersion(fk, fv)
+}
+
+// detectVersion read version from headers of files
+func detectVersion(fk, fv *os.File) (version uint8, err error) {
+	version, _, err = readHeader(fk, keyMagic)
+	if err != nil || version == 0 {
+		// values of version 0 may start with anything
+		return version, err
+	}
+	valVersion, _, err := readHeader(fv, valMagic)
+	if err == nil && valVersion != version {
+		err = ErrUnknownFormat
+	}
+	return version, err
+}
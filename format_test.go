@@ -0,0 +1,214 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+// createV0 write store in format of version 0 like old versions of gig
+func createV0(t *testing.T, f string, count int) {
+	DeleteFile(f)
+	checkAndCreate(f)
+	fk, err := os.Create(f + KEY_FILE_EXT)
+	ch(err, t)
+	fv, err := os.Create(f + VAL_FILE_EXT)
+	ch(err, t)
+	defer fk.Close()
+	defer fv.Close()
+	for i := 0; i < count; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		seek, _, err := writeAtPos(fv, k, -1, false)
+		ch(err, t)
+		_, err = writeKey(fk, &keyRecord{seek: uint64(seek), size: uint32(len(k)), key: k}, false)
+		ch(err, t)
+	}
+}
+
+func TestFormatV0(t *testing.T) {
+	f := "tests/TestFormatV0.db"
+	createV0(t, f, 10)
+	defer CloseAll()
+
+	check := func(count int) {
+		for i := 0; i < count; i++ {
+			k := []byte(fmt.Sprintf("%04d", i))
+			v, err := Get(f, k)
+			if err != nil || !bytes.Equal(k, v) {
+				t.Error("not equal", string(k), string(v), err)
+			}
+		}
+	}
+	check(10)
+	// files of version 0 stay in version 0
+	ch(Set(f, []byte("0010"), []byte("0010")), t)
+	Close(f)
+	check(11)
+	b, _ := os.ReadFile(f + KEY_FILE_EXT)
+	if b[0] != 0 || bytes.HasPrefix(b, keyMagic) {
+		t.Error("version 0 file changed format")
+	}
+
+	ch(Upgrade(f), t)
+	check(11)
+	Close(f)
+	check(11)
+	for name, magic := range map[string][]byte{f + KEY_FILE_EXT: keyMagic, f + VAL_FILE_EXT: valMagic} {
+		fh, err := os.Open(name)
+		ch(err, t)
+		version, _, err := readHeader(fh, magic)
+		fh.Close()
+		if err != nil || version != FORMAT_VERSION {
+			t.Error("not upgraded", name, version, err)
+		}
+	}
+	// upgrade of current version is nothing
+	ch(Upgrade(f), t)
+	check(11)
+}
+
+func TestFormatLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeKey(&buf, &keyRecord{seek: math.MaxUint32 + 1, size: 1, key: []byte("key")}); err != ErrNeedUpgrade {
+		t.Error("large seek in version 0", err)
+	}
+	if err := encodeKey(&buf, &keyRecord{size: 1, key: make([]byte, math.MaxUint16+1)}); err != ErrNeedUpgrade {
+		t.Error("large key in version 0", err)
+	}
+	if err := encodeKey(&buf, &keyRecord{cmd: flagExpire, expire: 1, key: []byte("key")}); err != ErrNeedUpgrade {
+		t.Error("expire in version 0", err)
+	}
+	for _, version := range []uint8{0, 1, 2} {
+		buf.Reset()
+		ch(encodeKey(&buf, &keyRecord{version: version, cmd: 1, seek: 1 << 20, size: 42, crc: 7, key: []byte("key")}), t)
+		rec, n, err := decodeKey(buf.Bytes())
+		ch(err, t)
+		if n != buf.Len() || int64(n) != keyRecordSize(version, 1, []byte("key")) {
+			t.Error("wrong size", version, n)
+		}
+		if rec.cmd != 1 || rec.seek != 1<<20 || rec.size != 42 || string(rec.key) != "key" {
+			t.Error("wrong record", version, rec)
+		}
+		if _, _, err = decodeKey(buf.Bytes()[:n-1]); err != errTruncated {
+			t.Error("not truncated", version, err)
+		}
+		if version >= 2 {
+			buf.Bytes()[3]++
+			if _, _, err = decodeKey(buf.Bytes()); err != errChecksum {
+				t.Error("damage not detected", err)
+			}
+		}
+	}
+	buf.Reset()
+	ch(encodeKey(&buf, &keyRecord{version: 1, seek: math.MaxUint32 + 1, size: 1, key: make([]byte, math.MaxUint16+1)}), t)
+	if rec, _, err := decodeKey(buf.Bytes()); err != nil || rec.seek != math.MaxUint32+1 {
+		t.Error("large record in version 1", err)
+	}
+	buf.Reset()
+	ch(encodeKey(&buf, &keyRecord{version: FORMAT_VERSION, cmd: flagExpire, expire: 1 << 40, key: []byte("key")}), t)
+	if rec, n, err := decodeKey(buf.Bytes()); err != nil || rec.expire != 1<<40 || int64(n) != keyRecordSize(FORMAT_VERSION, flagExpire, []byte("key")) {
+		t.Error("expire not decoded", rec.expire, err)
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	f := "tests/TestTornTail.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 3; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	Close(f)
+	rec, err := Recovered(f)
+	if rec != nil || err != nil {
+		t.Error("recovered without damage", rec, err)
+	}
+	Close(f)
+
+	// half of record written before crash
+	b, err := os.ReadFile(f + KEY_FILE_EXT)
+	ch(err, t)
+	size := len(b)
+	recSize := int(keyRecordSize(FORMAT_VERSION, cmdSet, []byte("0000")))
+	ch(os.WriteFile(f+KEY_FILE_EXT, append(b, b[size-recSize:size-recSize/2]...), FILE_MODE), t)
+	rec, err = Recovered(f)
+	ch(err, t)
+	if rec == nil || rec.Offset != int64(size) || rec.Dropped != int64(recSize/2) || rec.Err != errTruncated {
+		t.Error("torn tail not dropped", rec)
+	}
+	if cnt, _ := Count(f); cnt != 3 {
+		t.Error("wrong count", cnt)
+	}
+	Close(f)
+	if info, _ := os.Stat(f + KEY_FILE_EXT); info.Size() != int64(size) {
+		t.Error("not truncated", info.Size())
+	}
+
+	// last record damaged, records covered by checkpoint are not read
+	b[size-1]++
+	ch(os.WriteFile(f+KEY_FILE_EXT, b, FILE_MODE), t)
+	os.Remove(f + CHECKPOINT_FILE_EXT)
+	rec, err = Recovered(f)
+	ch(err, t)
+	if rec == nil || rec.Offset != int64(size-recSize) || rec.Err != errChecksum {
+		t.Error("damaged record not dropped", rec)
+	}
+	if _, err = Get(f, []byte("0002")); err != ErrKeyNotFound {
+		t.Error("damaged record found", err)
+	}
+	if v, err := Get(f, []byte("0001")); err != nil || string(v) != "0001" {
+		t.Error("not equal", string(v), err)
+	}
+}
+
+func TestZeroTail(t *testing.T) {
+	f := "tests/TestZeroTail.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("a"), []byte("1")), t)
+	Close(f)
+
+	// space allocated before crash, but not written
+	b, err := os.ReadFile(f + KEY_FILE_EXT)
+	ch(err, t)
+	ch(os.WriteFile(f+KEY_FILE_EXT, append(b, make([]byte, 48)...), FILE_MODE), t)
+	os.Remove(f + CHECKPOINT_FILE_EXT)
+	rec, err := Recovered(f)
+	ch(err, t)
+	if rec == nil || rec.Offset != int64(len(b)) || rec.Dropped != 48 || rec.Err != errVersion {
+		t.Error("zero tail not dropped", rec)
+	}
+	if cnt, _ := Count(f); cnt != 1 {
+		t.Error("wrong count", cnt)
+	}
+	Close(f)
+	if info, _ := os.Stat(f + KEY_FILE_EXT); info.Size() != int64(len(b)) {
+		t.Error("not truncated", info.Size())
+	}
+}
+
+func TestCorruptedValue(t *testing.T) {
+	f := "tests/TestCorruptedValue.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("key1"), []byte("val1")), t)
+	ch(Set(f, []byte("key2"), []byte("val2")), t)
+	Close(f)
+
+	b, err := os.ReadFile(f + VAL_FILE_EXT)
+	ch(err, t)
+	b[len(b)-1] = 'X'
+	ch(os.WriteFile(f+VAL_FILE_EXT, b, FILE_MODE), t)
+	if _, err = Get(f, []byte("key2")); err != ErrCorrupted {
+		t.Error("damaged value not detected", err)
+	}
+	if v, err := Get(f, []byte("key1")); err != nil || string(v) != "val1" {
+		t.Error("not equal", string(v), err)
+	}
+	if pairs := Gets(f, [][]byte{[]byte("key1"), []byte("key2")}); len(pairs) != 2 {
+		t.Error("damaged value returned by Gets", len(pairs))
+	}
+}
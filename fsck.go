@@ -0,0 +1,250 @@
+package gig
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Problem - damaged record found by Check
+type Problem struct {
+	// Offset - offset of key record in keys file
+	Offset int64
+	Key    []byte
+	Err    error
+}
+
+// Report - result of Check
+type Report struct {
+	Version uint8
+	// Records - count of good records in keys file
+	Records int
+	Sets    int
+	// Deletes - count of delete records of keys and ranges
+	Deletes int
+	// Batches - count of committed write batches
+	Batches int
+	// Keys - count of live keys
+	Keys      int
+	KeySize   int64
+	ValSize   int64
+	DeadBytes int64
+	// Tail not nil if keys file has damaged tail, it will be dropped on Open
+	Tail     *Recovery
+	Problems []Problem
+}
+
+// Ok return true if no damage found
+func (r *Report) Ok() bool {
+	return r.Tail == nil && len(r.Problems) == 0
+}
+
+func (r *Report) problem(offset uint64, key []byte, err error) {
+	r.Problems = append(r.Problems, Problem{Offset: int64(offset), Key: key, Err: err})
+}
+
+// openCheck open files of store for reading without creating, store locked by shared lock like by OpenReadOnly
+func openCheck(file string) (s *store, err error) {
+	if _, err = os.Stat(file + KEY_FILE_EXT); err != nil {
+		return nil, err
+	}
+	// writer see checker as reader, so values are not overwritten and files are not swapped
+	fl, err := lockStore(file+READ_LOCK_FILE_EXT, true, 0)
+	if err != nil {
+		return nil, err
+	}
+	s = &store{file: file, fl: fl}
+	if s.fk, s.fv, s.version, _, err = openReadFiles(file); err != nil {
+		unlockStore(fl)
+		return nil, err
+	}
+	return s, nil
+}
+
+// closeCheck close files of store opened by openCheck and release its lock
+func (s *store) closeCheck() {
+	s.fk.Close()
+	s.fv.Close()
+	unlockStore(s.fl)
+}
+
+// check replay records like on open and check every live value
+func (s *store) check() (*Report, error) {
+	b, err := ioutil.ReadAll(s.fk)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.fv.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s.valSize = info.Size()
+	r := &Report{Version: s.version, KeySize: int64(len(b)), ValSize: s.valSize}
+	s.reset()
+	end, err := s.load(b, 0, func(rec keyRecord, offset int) {
+		r.Records++
+		switch rec.cmd & cmdMask {
+		case cmdSet:
+			r.Sets++
+		case cmdDelete, cmdDeleteRange:
+			r.Deletes++
+		case cmdCommit:
+			r.Batches++
+		default:
+			r.problem(uint64(offset), append([]byte{}, rec.key...), ErrUnknownCommand)
+		}
+	})
+	if err != nil {
+		r.Tail = &Recovery{Offset: int64(end), Dropped: int64(len(b) - end), Err: err}
+	}
+	s.keySize = int64(end)
+	s.indexAll()
+	r.Keys = s.index.len()
+
+	var start uint64
+	if s.version > 0 {
+		start = HEADER_SIZE
+	}
+	// live values ordered by offset, to find overlaps
+	live := make([][]byte, 0, s.index.len())
+	s.index.ascend(0, func(n *node) bool {
+		key, cmd := n.key, n.cmd
+		if cmd.Seek < start || cmd.Seek+uint64(cmd.Size) > uint64(s.valSize) {
+			r.problem(cmd.KeySeek, key, ErrValueRange)
+			return true
+		}
+		// key of store is unknown, so only checksum of sealed value checked
+		b, err := readStored(s.fv, s.version, cmd)
+		if err == nil && !cmd.Sealed {
+			_, err = decompress(cmd.Codec, b)
+		}
+		if err != nil {
+			r.problem(cmd.KeySeek, key, err)
+		}
+		live = append(live, key)
+		return true
+	})
+	sort.Slice(live, func(i, j int) bool {
+		return s.valDict[string(live[i])].Seek < s.valDict[string(live[j])].Seek
+	})
+	var maxEnd uint64
+	for _, key := range live {
+		cmd := s.valDict[string(key)]
+		if cmd.Size > 0 && cmd.Seek < maxEnd {
+			r.problem(cmd.KeySeek, key, ErrValueOverlap)
+		}
+		if end := cmd.Seek + uint64(cmd.Size); end > maxEnd {
+			maxEnd = end
+		}
+	}
+	sort.Slice(r.Problems, func(i, j int) bool {
+		return r.Problems[i].Offset < r.Problems[j].Offset
+	})
+	r.DeadBytes = s.deadBytes() + r.KeySize - s.keySize
+	return r, nil
+}
+
+// Check scan files of store without opening it
+// Records replayed like on Open and every live value checked
+// Writer see checker as reader, so values are not overwritten and files are not swapped while checking
+func Check(file string) (*Report, error) {
+	s, err := openCheck(file)
+	if err != nil {
+		return nil, err
+	}
+	defer s.closeCheck()
+	return s.check()
+}
+
+// sameStore return true if store and other have the same files, also by other path or symlink
+func sameStore(file, other string) bool {
+	for _, ext := range []string{KEY_FILE_EXT, VAL_FILE_EXT} {
+		info, err := os.Stat(file + ext)
+		if err != nil {
+			continue
+		}
+		if otherInfo, err := os.Stat(other + ext); err == nil && os.SameFile(info, otherInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+// Repair check store and write live keys with good values to new store dest
+// Dest written in current format version, damaged tail and values are not copied
+// Overlapped values copied if they pass checksum
+// Dest must not be the store itself and must not be opened, its files are truncated before copy
+func Repair(file, dest string) (*Report, error) {
+	if sameStore(file, dest) {
+		return nil, ErrSameFile
+	}
+	s, err := openCheck(file)
+	if err != nil {
+		return nil, err
+	}
+	defer s.closeCheck()
+	r, err := s.check()
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool)
+	for _, p := range r.Problems {
+		if p.Err != ErrValueOverlap {
+			skip[string(p.Key)] = true
+		}
+	}
+
+	if _, err = checkAndCreate(dest); err != nil {
+		return r, err
+	}
+	// files of dest are truncated, so it must not be opened by writer and readers
+	fl, err := lockStore(dest+LOCK_FILE_EXT, false, 0)
+	if err != nil {
+		return r, err
+	}
+	defer unlockStore(fl)
+	fr, err := lockStore(dest+READ_LOCK_FILE_EXT, false, 0)
+	if err != nil {
+		return r, err
+	}
+	defer unlockStore(fr)
+	c := &compaction{version: FORMAT_VERSION}
+	opts := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	if c.fv, err = os.OpenFile(dest+VAL_FILE_EXT, opts, FILE_MODE); err != nil {
+		return r, err
+	}
+	defer c.fv.Close()
+	if c.fk, err = os.OpenFile(dest+KEY_FILE_EXT, opts, FILE_MODE); err != nil {
+		return r, err
+	}
+	defer c.fk.Close()
+	if err = writeHeader(c.fv, valMagic, c.version, 0); err != nil {
+		return r, err
+	}
+	if err = writeHeader(c.fk, keyMagic, c.version, 0); err != nil {
+		return r, err
+	}
+	if s.version > 0 {
+		// sealed values copied as is, so dest has key of store
+		check, err := readKeyCheck(s.fv)
+		if err == nil && check != nil {
+			_, err = c.fv.WriteAt(check, keyCheckSeek)
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+	s.index.ascend(0, func(n *node) bool {
+		if !skip[string(n.key)] {
+			_, err = c.copyRecord(s.fv, s.version, n.key, n.cmd)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return r, err
+	}
+	if err = c.fv.Sync(); err != nil {
+		return r, err
+	}
+	return r, c.fk.Sync()
+}
@@ -0,0 +1,87 @@
+package gig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	f := "tests/TestCheck.db"
+	repaired := "tests/TestCheckRepaired.db"
+	DeleteFile(f)
+	DeleteFile(repaired)
+	defer CloseAll()
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	Delete(f, []byte("0000"))
+	ch(Set(f, []byte("0001"), []byte("00010001")), t)
+	Close(f)
+
+	r, err := Check(f)
+	ch(err, t)
+	if !r.Ok() || r.Records != 12 || r.Sets != 11 || r.Deletes != 1 || r.Keys != 9 {
+		t.Errorf("wrong report %+v", r)
+	}
+	// set and delete of 0000 with value, old record and value of 0001
+	if r.DeadBytes != 3*keyRecordSize(FORMAT_VERSION, cmdSet, []byte("0000"))+4+4 {
+		t.Error("wrong dead bytes", r.DeadBytes)
+	}
+
+	// damage value of 0009 and add torn record
+	b, _ := os.ReadFile(f + VAL_FILE_EXT)
+	b[HEADER_SIZE+9*4] = 'X'
+	os.WriteFile(f+VAL_FILE_EXT, b, FILE_MODE)
+	b, _ = os.ReadFile(f + KEY_FILE_EXT)
+	os.WriteFile(f+KEY_FILE_EXT, append(b, 2, 0, 0), FILE_MODE)
+
+	abs, _ := filepath.Abs(f)
+	os.Remove("tests/TestCheckLink.db" + KEY_FILE_EXT)
+	ch(os.Symlink(abs+KEY_FILE_EXT, "tests/TestCheckLink.db"+KEY_FILE_EXT), t)
+	defer os.Remove("tests/TestCheckLink.db" + KEY_FILE_EXT)
+	for _, dest := range []string{"tests/../tests/TestCheck.db", abs, "tests/TestCheckLink.db"} {
+		if _, err = Repair(f, dest); err != ErrSameFile {
+			t.Error("repaired to itself", dest, err)
+		}
+	}
+	r, err = Repair(f, repaired)
+	ch(err, t)
+	if r.Ok() || r.Tail == nil || r.Tail.Dropped != 3 {
+		t.Errorf("damaged tail not found %+v", r.Tail)
+	}
+	if len(r.Problems) != 1 || string(r.Problems[0].Key) != "0009" || r.Problems[0].Err != ErrCorrupted {
+		t.Errorf("damaged value not found %+v", r.Problems)
+	}
+	// Check not change files
+	if info, _ := os.Stat(f + KEY_FILE_EXT); info.Size() != int64(len(b)+3) {
+		t.Error("keys file changed", info.Size())
+	}
+
+	r, err = Check(repaired)
+	ch(err, t)
+	if !r.Ok() || r.Keys != 8 || r.DeadBytes != 0 {
+		t.Errorf("wrong repaired %+v", r)
+	}
+	if v, err := Get(repaired, []byte("0001")); err != nil || string(v) != "00010001" {
+		t.Error("not equal", string(v), err)
+	}
+	// opened store is not truncated
+	if _, err = Repair(f, repaired); err != ErrLocked {
+		t.Error("repaired to opened store", err)
+	}
+
+	// writer see checker as reader
+	db, _ := Open(repaired)
+	s, err := openCheck(repaired)
+	ch(err, t)
+	if !db.s.shared() {
+		t.Error("checker not seen by writer")
+	}
+	s.closeCheck()
+	if db.s.shared() {
+		t.Error("lock of checker not released")
+	}
+}
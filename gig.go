@@ -10,8 +10,8 @@ import (
 	"errors"
 	"os"
 	"reflect"
-	"runtime"
 	"sync"
+	"time"
 )
 
 const (
@@ -21,11 +21,23 @@ const (
 	KEY_FILE_EXT    = ".gik"
 	VAL_FILE_EXT    = ".giv"
 	NAME_COUNT_KEYS = "_LEN_KEYS_"
+	// COMPACT_FILE_EXT - suffix of files written by compaction
+	COMPACT_FILE_EXT = ".compact"
+	// CHECKPOINT_FILE_EXT - file with index of keys, see CheckpointBytes
+	CHECKPOINT_FILE_EXT = ".gic"
+	// LOCK_FILE_EXT - file locked by process which opened store
+	LOCK_FILE_EXT = ".gil"
+	// READ_LOCK_FILE_EXT - file locked by processes which opened store read-only
+	READ_LOCK_FILE_EXT = ".gir"
+	// REPLICA_FILE_EXT - file with position of replica, see Follow
+	REPLICA_FILE_EXT = ".gip"
 )
 
 var (
 	stores = make(map[string]*DB)
-	mutex  = &sync.RWMutex{}
+	// closing - DB removed from stores by Close, which still wait its calls and goroutine
+	closing = make(map[string]*DB)
+	mutex   = &sync.RWMutex{}
 
 	// ErrKeyNotFound - key not found
 	ErrKeyNotFound = errors.New("Error: key not found")
@@ -33,6 +45,42 @@ var (
 	ErrDbOpened = errors.New("Error: db is opened")
 	// ErrDbNotOpen - db not open
 	ErrDbNotOpen = errors.New("Error: db not open")
+	// ErrNeedUpgrade - store of old format can not hold this data, see Upgrade
+	ErrNeedUpgrade = errors.New("Error: db format must be upgraded")
+	// ErrUnknownFormat - files of store are not recognized
+	ErrUnknownFormat = errors.New("Error: unknown db format")
+	// ErrCorrupted - checksum of value mismatch
+	ErrCorrupted = errors.New("Error: value is corrupted")
+	// ErrValueRange - value of key is out of values file
+	ErrValueRange = errors.New("Error: value out of file")
+	// ErrValueOverlap - value of key overlap value of other key
+	ErrValueOverlap = errors.New("Error: value overlap other value")
+	// ErrUnknownCommand - key record has unknown command
+	ErrUnknownCommand = errors.New("Error: unknown command")
+	// ErrWrongKey - key of store not match key in options or value sealed by other key
+	ErrWrongKey = errors.New("Error: wrong encryption key")
+	// ErrLocked - store opened by other process, see Options.LockTimeout
+	ErrLocked = errors.New("Error: db is locked by other process")
+	// ErrReadOnly - store opened by OpenReadOnly can not be changed
+	ErrReadOnly = errors.New("Error: db is opened read-only")
+	// ErrCompacted - changes after sequence number was discarded by compaction, see ChangesSince
+	ErrCompacted = errors.New("Error: history of changes was compacted")
+	// ErrSameFile - destination of Repair is the store itself
+	ErrSameFile = errors.New("Error: destination is the same db")
+	// ErrFrameTooBig - change is bigger then frame accepted by follower, see ReplicationBatch
+	ErrFrameTooBig = errors.New("Error: change is too big for replication frame")
+
+	// AutoCompactRatio - compact store when dead bytes reach this part of files size (0 - disabled)
+	AutoCompactRatio = 0.0
+	// AutoCompactMinSize - stores smaller then this size (in bytes) not compacted automatically
+	AutoCompactMinSize int64 = 1 << 20
+	// SweepInterval - how often expired keys are deleted in background
+	SweepInterval = time.Second
+	// CheckpointBytes - index is written to checkpoint file in background,
+	// when keys file grow by this count of bytes (0 - disabled, see Checkpoint)
+	CheckpointBytes int64 = 1 << 20
+	// GroupWrites - max count of concurrent Set written together with one sync
+	GroupWrites = 128
 
 	bufPool = &sync.Pool{
 		New: func() interface{} {
@@ -60,44 +108,80 @@ type Collection interface {
 // Return error if any
 // Create .idx file for key storage
 func Open(file string) (db *DB, err error) {
+	return OpenWithOptions(file, nil)
+}
+
+// OpenWithOptions open/create DB like Open, nil opts - default options
+// Store opened once, other functions use its options
+// Return ErrDbOpened if store already opened with other options
+func OpenWithOptions(file string, opts *Options) (db *DB, err error) {
+	return openStore(file, opts.options(), opts != nil)
+}
+
+// OpenReadOnly open existing store for reading while other process may write it, nil opts - default options
+// Files of store are never written, store locked by shared lock, see Refresh
+// Writes of store opened read-only return ErrReadOnly
+func OpenReadOnly(file string, opts *Options) (db *DB, err error) {
+	o := opts.options()
+	o.readOnly = true
+	return openStore(file, o, true)
+}
+
+// openStore open DB or return opened one, if check is true options of opened DB must be the same
+func openStore(file string, o Options, check bool) (db *DB, err error) {
 	mutex.Lock()
 	defer mutex.Unlock()
+	// store can not be opened again until Close return
+	for c, ok := closing[file]; ok; c, ok = closing[file] {
+		mutex.Unlock()
+		<-c.done
+		mutex.Lock()
+		if closing[file] == c {
+			delete(closing, file)
+		}
+	}
 
 	v, ok := stores[file]
 	if ok {
+		v.s.mu.RLock()
+		same := v.s.opts.equal(o)
+		v.s.mu.RUnlock()
+		if check && !same {
+			return nil, ErrDbOpened
+		}
 		return v, nil
 	}
 
 	//fmt.Println("NewDB")
-	db, err = newDB(file)
+	db, err = newDB(file, o)
 	if err == nil {
 		stores[file] = db
 	}
 	return db, err
 }
 
-// Close - close DB and free used memory
-// It run finalizer and cancel goroutine
+// Close - close DB and free used memory, see DB.Close
+// Writes not synced yet are synced before files closed
 func Close(file string) (err error) {
 	mutex.Lock()
-	defer mutex.Unlock()
-	//db, ok := stores[file]
-	_, ok := stores[file]
+	db, ok := stores[file]
+	mutex.Unlock()
 	if !ok {
 		return ErrDbNotOpen
 	}
-	// store counters if present
-	//db.counterSet("", 0, true)
-	delete(stores, file)
-	/* Force GC, to require finalizer to run */
-	runtime.GC()
-	return err
+	return db.Close()
 }
 
 // CloseAll - close all opened DB
 func CloseAll() (err error) {
-
+	mutex.Lock()
+	files := make([]string, 0, len(stores))
 	for k := range stores {
+		files = append(files, k)
+	}
+	mutex.Unlock()
+
+	for _, k := range files {
 		err = Close(k)
 		if err != nil {
 			break
@@ -117,6 +201,12 @@ func DeleteFile(file string) (err error) {
 		return err
 	}
 	err = os.Remove(file + VAL_FILE_EXT)
+	os.Remove(file + CHECKPOINT_FILE_EXT)
+	os.Remove(file + CHECKPOINT_FILE_EXT + COMPACT_FILE_EXT)
+	recoverCompact(file)
+	os.Remove(file + LOCK_FILE_EXT)
+	os.Remove(file + READ_LOCK_FILE_EXT)
+	os.Remove(file + REPLICA_FILE_EXT)
 	return err
 }
 
@@ -130,10 +220,59 @@ func Set(file string, key []byte, val []byte) (err error) {
 	if err != nil {
 		return err
 	}
-	err = db.setKey(string(key), val)
+	err = db.setKey(string(key), val, 0)
 	return err
 }
 
+// SetWithTTL store val and key like Set, key will expire after ttl
+// Expired keys are not returned and deleted from files in background
+// Set without ttl remove expiration of key
+func SetWithTTL(file string, key []byte, val []byte, ttl time.Duration) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	return db.setKey(string(key), val, expireAt(ttl))
+}
+
+// Expire set time to live of existing key, ttl <= 0 remove expiration
+// Return ErrKeyNotFound if key not exists or expired
+func Expire(file string, key []byte, ttl time.Duration) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	_, err = db.expire(string(key), expireAt(ttl), true)
+	return err
+}
+
+// TTL return remaining time to live of key, 0 if key has no expiration
+// Return ErrKeyNotFound if key not exists or expired
+func TTL(file string, key []byte) (ttl time.Duration, err error) {
+	db, err := Open(file)
+	if err != nil {
+		return 0, err
+	}
+	expire, err := db.expire(string(key), 0, false)
+	if err != nil || expire == 0 {
+		return 0, err
+	}
+	ttl = time.Until(time.UnixMilli(expire))
+	if ttl <= 0 {
+		// expired right now, but not swept yet
+		ttl = time.Millisecond
+	}
+	return ttl, nil
+}
+
+// expireAt return expiration time in unix milliseconds for ttl, 0 if ttl <= 0
+func expireAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixMilli()
+}
+
 // Put store val and key with sync at end. It's wrapper for Set.
 func Put(file string, key []byte, val []byte) (err error) {
 	return Set(file, key, val)
@@ -164,7 +303,7 @@ func SetGob(file string, key interface{}, val interface{}) (err error) {
 		return err
 	}
 
-	err = db.setKey(bufKey.String(), bufVal.Bytes())
+	err = db.setKey(bufKey.String(), bufVal.Bytes(), 0)
 	//fmt.Println(bufKey.Bytes())
 	return err
 }
@@ -176,8 +315,7 @@ func Has(file string, key []byte) (exist bool, err error) {
 	if err != nil {
 		return false, err
 	}
-	exist = db.has(string(key))
-	return exist, err
+	return db.has(string(key))
 }
 
 // Count return count of keys or error if any
@@ -186,8 +324,7 @@ func Count(file string) (cnt uint64, err error) {
 	if err != nil {
 		return 0, err
 	}
-	cnt = db.countKeys()
-	return cnt, err
+	return db.countKeys()
 }
 
 // Counter return unique uint64
@@ -206,13 +343,13 @@ func Counter(file string, key []byte) (counter uint64, err error) {
 		counter++
 		b := make([]byte, 8)
 		binary.BigEndian.PutUint64(b, counter)
-		err = db.setKey(string(key), b)
+		err = db.setKey(string(key), b, 0)
 		return counter, err
 	}
 	if err == ErrKeyNotFound {
 		b := make([]byte, 8)
 		binary.BigEndian.PutUint64(b, uint64(1))
-		err = db.setKey(string(key), b)
+		err = db.setKey(string(key), b, 0)
 		return uint64(1), err
 	}
 	return counter, err
@@ -288,13 +425,13 @@ func GetGob(file string, key interface{}, val interface{}) (err error) {
 // if offset>0 - skip offset records
 // If from not nil - return keys after from (from not included)
 // If last byte of from == "*" - return keys with this prefix
+// Use NewIterator for bounds, prefixes and keys ending with "*"
 func Keys(file string, from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
 	db, err := Open(file)
 	if err != nil {
 		return nil, err
 	}
-	val := db.readKeys(from, limit, offset, asc)
-	return val, err
+	return db.readKeys(from, limit, offset, asc)
 }
 
 // Gets return key/value pairs in random order
@@ -311,6 +448,7 @@ func Gets(file string, keys [][]byte) (result [][]byte) {
 
 // Sets store vals and keys
 // Sync will called only at end of insertion
+// Pairs written as WriteBatch, so they are atomic (except stores of version 0)
 // Use it for mass insertion
 // every pair must contain key and value
 func Sets(file string, pairs [][]byte) (err error) {
@@ -324,7 +462,98 @@ func Sets(file string, pairs [][]byte) (err error) {
 	return err
 }
 
-// Delete key (always return true)
+// Write store all puts and deletes of batch with sync at end
+// Batch is atomic: after crash all operations are visible or none of them
+// Stores of version 0 must be upgraded, see Upgrade
+func Write(file string, batch *WriteBatch) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	return db.writeBatch(batch.ops)
+}
+
+// Compact rewrite live keys and values into new files and swap them with old
+// Reads and writes are served while compaction in progress
+// Return count of reclaimed bytes or error if any
+func Compact(file string) (reclaimed int64, err error) {
+	db, err := Open(file)
+	if err != nil {
+		return 0, err
+	}
+	return db.compact(compactRequest{})
+}
+
+// Upgrade rewrite store in current format (see FORMAT_VERSION)
+// Stores of version 0 are read and written as is,
+// but they can not address values after 4GB and keys longer 64KB
+// Upgrade is compaction, so reads and writes are served while it in progress
+func Upgrade(file string) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	_, err = db.compact(compactRequest{upgrade: true})
+	return err
+}
+
+// Rotate seal all values by new key, nil key - values stored not sealed
+// Values sealed again by compaction, so reads and writes are served while it in progress
+// After rotation store must be opened with new key, see Options
+func Rotate(file string, key []byte) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		key = append([]byte{}, key...)
+	}
+	_, err = db.compact(compactRequest{rotate: true, key: key})
+	return err
+}
+
+// Checkpoint write index of store to checkpoint file, so on next open
+// only records written after it will be replayed
+// Checkpoint is also written in background, see CheckpointBytes
+func Checkpoint(file string) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	return db.checkpoint()
+}
+
+// Sync write to disk all writes of store not synced yet, see Options
+func Sync(file string) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	return db.sync()
+}
+
+// Refresh read records appended to store opened read-only by writer process after open or last refresh
+// If writer compacted store, new files are opened. Store opened for writing is not changed
+// See also Options.RefreshInterval
+func Refresh(file string) (err error) {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	return db.refresh()
+}
+
+// Recovered return info about damaged records dropped from the end of keys file on open
+// Return nil if all records was read
+func Recovered(file string) (*Recovery, error) {
+	db, err := Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return db.recovery, nil
+}
+
+// Delete key (always return true if store is opened)
 // Delete not remove any data from files
 // Return error if any
 func Delete(file string, key []byte) (deleted bool, err error) {
@@ -332,8 +561,61 @@ func Delete(file string, key []byte) (deleted bool, err error) {
 	if err != nil {
 		return deleted, err
 	}
-	db.deleteKey(string(key))
-	return true, err
+	err = db.deleteKey(string(key))
+	return err == nil, err
+}
+
+// Watch return events of changes of keys with prefix (nil - all keys) and function to cancel watch
+// Events sent after every write, if watcher does not read them and buffer of WatchBuffer
+// events is full, events are dropped and EVENT_OVERFLOW with count of them sent later
+// Events channel closed by cancel and on Close of store
+func Watch(file string, prefix []byte) (<-chan Event, func(), error) {
+	db, err := Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db.watch(prefix)
+}
+
+// ChangesSince return changes of store after change with sequence number seq in commit order
+// seq 0 - from first record of keys file, limit 0 - all changes, records of batch are not split by limit
+// Every compaction, also automatic one, discard changes of old keys file and return ErrCompacted for them,
+// only seq of last change before last compaction (LastSeq) is continued until store is closed
+// Changes from seq 0 start with all live keys
+// Return ErrNeedUpgrade for stores of version before 2
+func ChangesSince(file string, seq uint64, limit int) ([]Change, error) {
+	db, err := Open(file)
+	if err != nil {
+		return nil, err
+	}
+	changes, _, err := db.changes(seq, limit)
+	return changes, err
+}
+
+// LastSeq return sequence number after last change of store, ChangesSince return changes after it
+func LastSeq(file string) (uint64, error) {
+	db, err := Open(file)
+	if err != nil {
+		return 0, err
+	}
+	_, seq, err := db.changes(0, -1)
+	return seq, err
+}
+
+// DeleteRange delete keys from start to end (end excluded, nil end - to last key)
+// Keys deleted at once with one record in keys file and one sync
+// Return count of deleted keys
+func DeleteRange(file string, start, end []byte) (deleted int, err error) {
+	db, err := Open(file)
+	if err != nil {
+		return 0, err
+	}
+	return db.deleteRange(start, end)
+}
+
+// DeletePrefix delete keys with prefix like DeleteRange
+func DeletePrefix(file string, prefix []byte) (deleted int, err error) {
+	return DeleteRange(file, prefix, prefixEnd(prefix))
 }
 
 func Id2Bin(id uint32) []byte {
@@ -357,11 +639,13 @@ func (gig *Gig) Clear() error {
 }
 
 func (gig *Gig) Close() error {
+	gig.db = nil
 	return Close(gig.file)
 }
 
+// Reconnect open store if it is not opened or was closed
 func (gig *Gig) Reconnect() error {
-	if gig.db != nil {
+	if gig.db != nil && !gig.db.closed() {
 		return nil
 	}
 	db, err := Open(gig.file)
@@ -418,7 +702,10 @@ func (gig *Gig) Find(from []byte, limit int, offset int, asc bool) ([]Row, error
 		return nil, errors.New("There is no RowCreator()")
 	}
 	var rows []Row
-	keys := gig.db.readKeys(from, uint32(limit), uint32(offset), asc)
+	keys, err := gig.db.readKeys(from, uint32(limit), uint32(offset), asc)
+	if err != nil {
+		return nil, err
+	}
 	for i, bins := range gig.db.gets(keys) {
 		if i%2 == 0 {
 			continue
@@ -461,7 +748,7 @@ func (gig *Gig) Set(key string, row Row) error {
 	if err != nil {
 		return err
 	}
-	err = gig.db.setKey(key, bins)
+	err = gig.db.setKey(key, bins, 0)
 	return err
 }
 
@@ -469,14 +756,12 @@ func (gig *Gig) Del(key string) error {
 	if err := gig.Reconnect(); err != nil {
 		return err
 	}
-	gig.db.deleteKey(key)
-	return nil
+	return gig.db.deleteKey(key)
 }
 
 func (gig *Gig) Has(key string) (bool, error) {
 	if err := gig.Reconnect(); err != nil {
 		return false, err
 	}
-	exist := gig.db.has(key)
-	return exist, nil
+	return gig.db.has(key)
 }
@@ -89,6 +89,76 @@ func TestOpen(t *testing.T) {
 	fmt.Println(ok)
 }
 
+func TestCloseHandle(t *testing.T) {
+	f := "tests/TestCloseHandle.db"
+	DeleteFile(f)
+	defer CloseAll()
+	db, err := Open(f)
+	ch(err, t)
+	ch(Set(f, []byte("foo"), []byte("bar")), t)
+	ch(db.Close(), t)
+	if err = db.Close(); err != ErrDbNotOpen {
+		t.Error("closed twice", err)
+	}
+	if err = db.setKey("foo", []byte("baz"), 0); err != ErrDbNotOpen {
+		t.Error("set on closed handle", err)
+	}
+	if _, err = db.readKey("foo"); err != ErrDbNotOpen {
+		t.Error("get on closed handle", err)
+	}
+	if _, err = db.readKeys(nil, 0, 0, true); err != ErrDbNotOpen {
+		t.Error("keys on closed handle", err)
+	}
+	if _, err = db.has("foo"); err != ErrDbNotOpen {
+		t.Error("has on closed handle", err)
+	}
+	if err = Close(f); err != ErrDbNotOpen {
+		t.Error("store not closed", err)
+	}
+
+	// new handle on open
+	other, err := Open(f)
+	ch(err, t)
+	if other == db {
+		t.Error("closed handle reused")
+	}
+	if v, err := Get(f, []byte("foo")); err != nil || string(v) != "bar" {
+		t.Error("not equal", string(v), err)
+	}
+
+	// Gig reconnect after store closed
+	gig := NewGig(f, nil)
+	ch(gig.Reconnect(), t)
+	ch(Close(f), t)
+	if has, err := gig.Has("foo"); err != nil || !has {
+		t.Error("not reconnected", has, err)
+	}
+
+	// calls in progress are waited by Close without global lock
+	db, err = Open(f)
+	ch(err, t)
+	db.enter()
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+	for !db.closed() {
+		time.Sleep(time.Millisecond)
+	}
+	ch(Set("tests/TestCloseHandleOther.db", []byte("foo"), []byte("bar")), t)
+	opened := make(chan *DB)
+	go func() {
+		other, _ := Open(f)
+		opened <- other
+	}()
+	time.Sleep(10 * time.Millisecond)
+	db.leave()
+	ch(<-closed, t)
+	if other := <-opened; other == nil || other == db {
+		t.Error("store not opened again after Close")
+	}
+}
+
 func TestAsync(t *testing.T) {
 	len := 5
 	file := "tests/async.db"
@@ -828,3 +898,143 @@ func TestAsterix(t *testing.T) {
 	*/
 	Close(f)
 }
+
+func TestConcurrentReadWrite(t *testing.T) {
+	f := "tests/TestConcurrentReadWrite.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				k := []byte(fmt.Sprintf("%04d", i))
+				// same size, so value overwritten in place while it read
+				if err := Set(f, k, []byte(fmt.Sprintf("%04d", 99-i))); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				k := []byte(fmt.Sprintf("%04d", i%100))
+				v, err := Get(f, k)
+				if err != nil || len(v) != 4 {
+					t.Error("bad read", string(k), string(v), err)
+				}
+				if has, _ := Has(f, k); !has {
+					t.Error("key not found", string(k))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if cnt, _ := Count(f); cnt != 100 {
+		t.Error("wrong count", cnt)
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	f := "tests/TestGroupCommit.db"
+	DeleteFile(f)
+	defer CloseAll()
+	var wg sync.WaitGroup
+	for w := 0; w < 100; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				k := []byte(fmt.Sprintf("%02d%02d", w, i))
+				if err := Set(f, k, k); err != nil {
+					t.Error(err)
+				}
+				// same key written by all writers
+				if err := Set(f, []byte("last"), k); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	last, err := Get(f, []byte("last"))
+	ch(err, t)
+	check := func() {
+		if cnt, _ := Count(f); cnt != 1001 {
+			t.Error("wrong count", cnt)
+		}
+		for _, k := range [][]byte{[]byte("0000"), []byte("5005"), []byte("9909")} {
+			if v, err := Get(f, k); err != nil || !bytes.Equal(v, k) {
+				t.Error("not equal", string(k), string(v), err)
+			}
+		}
+		if v, err := Get(f, []byte("last")); err != nil || !bytes.Equal(v, last) {
+			t.Error("wrong last write", string(v), string(last), err)
+		}
+	}
+	check()
+	Close(f)
+	check()
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	f := "tests/BenchmarkGetParallel.db"
+	DeleteFile(f)
+	defer CloseAll()
+	var pairs [][]byte
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		pairs = append(pairs, k, k)
+	}
+	Sets(f, pairs)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			Get(f, pairs[(i%1000)*2])
+			i++
+		}
+	})
+}
+
+func BenchmarkGetWhileSet(b *testing.B) {
+	f := "tests/BenchmarkGetWhileSet.db"
+	DeleteFile(f)
+	defer CloseAll()
+	var pairs [][]byte
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		pairs = append(pairs, k, k)
+	}
+	Sets(f, pairs)
+	done := make(chan struct{})
+	go func() {
+		// writer with fsync on every Set must not block readers
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				Set(f, []byte("writer"), []byte(fmt.Sprintf("%d", i)))
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			Get(f, pairs[(i%1000)*2])
+			i++
+		}
+	})
+	b.StopTimer()
+	close(done)
+}
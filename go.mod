@@ -1,5 +1,7 @@
 module github.com/azhai/gig
 
+go 1.23
+
 replace (
 	golang.org/x/crypto => github.com/golang/crypto v0.0.0-20181015023909-0c41d7ab0a0e
 	golang.org/x/net => github.com/golang/net v0.0.0-20181011144130-49bb7cea24b1
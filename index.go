@@ -0,0 +1,243 @@
+package gig
+
+import (
+	"bytes"
+	"hash/crc32"
+	"sort"
+)
+
+// node of ordered index, it is treap with sizes of subtrees
+// Nodes are never changed after they added to index, put and remove return new root
+// and copy only path to changed node, so old root is frozen view of index
+type node struct {
+	key   []byte
+	cmd   *Cmd
+	prio  uint32
+	size  int
+	left  *node
+	right *node
+}
+
+// len return count of keys in tree
+func (n *node) len() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// fix update size of node after change of children
+func (n *node) fix() *node {
+	n.size = 1 + n.left.len() + n.right.len()
+	return n
+}
+
+// get return command of key or nil
+func (n *node) get(key []byte) *Cmd {
+	for n != nil {
+		switch c := bytes.Compare(key, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n.cmd
+		}
+	}
+	return nil
+}
+
+// put return tree with key and its command
+// Priority is hash of key, so tree shape does not depend on order of inserts
+func (n *node) put(key []byte, cmd *Cmd) *node {
+	return n.insert(&node{key: key, cmd: cmd, prio: crc32.Checksum(key, crcTable), size: 1})
+}
+
+func (n *node) insert(nn *node) *node {
+	if n == nil {
+		return nn
+	}
+	m := *n
+	switch c := bytes.Compare(nn.key, n.key); {
+	case c < 0:
+		m.left = n.left.insert(nn)
+		if m.left.prio > m.prio {
+			// left child is new copy, so it may be changed
+			l := m.left
+			m.left = l.right
+			l.right = m.fix()
+			return l.fix()
+		}
+	case c > 0:
+		m.right = n.right.insert(nn)
+		if m.right.prio > m.prio {
+			r := m.right
+			m.right = r.left
+			r.left = m.fix()
+			return r.fix()
+		}
+	default:
+		// key exists, keep old key, it may be shared with views
+		m.cmd = nn.cmd
+		return &m
+	}
+	return m.fix()
+}
+
+// remove return tree without key
+func (n *node) remove(key []byte) *node {
+	if n == nil {
+		return nil
+	}
+	m := *n
+	switch c := bytes.Compare(key, n.key); {
+	case c < 0:
+		if m.left = n.left.remove(key); m.left == n.left {
+			return n
+		}
+	case c > 0:
+		if m.right = n.right.remove(key); m.right == n.right {
+			return n
+		}
+	default:
+		return merge(n.left, n.right)
+	}
+	return m.fix()
+}
+
+// merge join trees, all keys of a must be less then keys of b
+func merge(a, b *node) *node {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.prio > b.prio {
+		m := *a
+		m.right = merge(a.right, b)
+		return m.fix()
+	}
+	m := *b
+	m.left = merge(a, b.left)
+	return m.fix()
+}
+
+// search return count of first keys for which less is true
+// less must be true for keys before some key and false after it
+func (n *node) search(less func(key []byte) bool) int {
+	i := 0
+	for n != nil {
+		if less(n.key) {
+			i += n.left.len() + 1
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return i
+}
+
+// at return node with position i in ascending order
+func (n *node) at(i int) *node {
+	for n != nil {
+		l := n.left.len()
+		switch {
+		case i < l:
+			n = n.left
+		case i > l:
+			i -= l + 1
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+// ascend call fn for nodes from position i in ascending order, while fn return true
+func (n *node) ascend(i int, fn func(n *node) bool) bool {
+	if n == nil {
+		return true
+	}
+	l := n.left.len()
+	if i < l && !n.left.ascend(i, fn) {
+		return false
+	}
+	if i <= l && !fn(n) {
+		return false
+	}
+	if i > l {
+		return n.right.ascend(i-l-1, fn)
+	}
+	return n.right.ascend(0, fn)
+}
+
+// descend call fn for nodes from position i in descending order, while fn return true
+func (n *node) descend(i int, fn func(n *node) bool) bool {
+	if n == nil || i < 0 {
+		return true
+	}
+	l := n.left.len()
+	if i > l && !n.right.descend(i-l-1, fn) {
+		return false
+	}
+	if i >= l && !fn(n) {
+		return false
+	}
+	if i < l {
+		return n.left.descend(i, fn)
+	}
+	return n.left.descend(l-1, fn)
+}
+
+// indexAll build index from all keys of valDict
+func (s *store) indexAll() {
+	keys := make([][]byte, 0, len(s.valDict))
+	for key := range s.valDict {
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	cmds := make([]*Cmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = s.valDict[string(key)]
+	}
+	s.index = buildIndex(keys, cmds)
+	s.loading = false
+}
+
+// buildIndex return index of sorted keys in linear time
+// Nodes are stacked by priority like on insert, so tree is the same as after puts
+func buildIndex(keys [][]byte, cmds []*Cmd) *node {
+	var stack []*node
+	for i, key := range keys {
+		n := &node{key: key, cmd: cmds[i], prio: crc32.Checksum(key, crcTable), size: 1}
+		var last *node
+		for len(stack) > 0 && stack[len(stack)-1].prio < n.prio {
+			last = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+		n.left = last
+		if len(stack) > 0 {
+			stack[len(stack)-1].right = n
+		}
+		stack = append(stack, n)
+	}
+	if len(stack) == 0 {
+		return nil
+	}
+	stack[0].fixAll()
+	return stack[0]
+}
+
+// fixAll update sizes of all nodes of new tree
+func (n *node) fixAll() {
+	if n == nil {
+		return
+	}
+	n.left.fixAll()
+	n.right.fixAll()
+	n.fix()
+}
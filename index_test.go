@@ -0,0 +1,129 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	var index *node
+	keys := make(map[string]bool)
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 5000; i++ {
+		k := fmt.Sprintf("%05d", r.Intn(2000))
+		if r.Intn(3) == 0 {
+			index = index.remove([]byte(k))
+			delete(keys, k)
+		} else {
+			index = index.put([]byte(k), &Cmd{Size: uint32(i)})
+			keys[k] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	if index.len() != len(sorted) {
+		t.Fatal("wrong len", index.len(), len(sorted))
+	}
+	i := 0
+	index.ascend(0, func(n *node) bool {
+		if string(n.key) != sorted[i] {
+			t.Fatal("wrong order", i, string(n.key), sorted[i])
+		}
+		i++
+		return true
+	})
+	for _, pos := range []int{0, 1, len(sorted) / 2, len(sorted) - 1} {
+		if string(index.at(pos).key) != sorted[pos] {
+			t.Error("wrong at", pos)
+		}
+		if found := index.search(func(key []byte) bool {
+			return bytes.Compare(key, []byte(sorted[pos])) < 0
+		}); found != pos {
+			t.Error("wrong search", pos, found)
+		}
+		var desc []string
+		index.descend(pos, func(n *node) bool {
+			desc = append(desc, string(n.key))
+			return len(desc) < 3
+		})
+		if desc[0] != sorted[pos] || pos >= 2 && desc[2] != sorted[pos-2] {
+			t.Error("wrong descend", pos, desc)
+		}
+	}
+
+	// built index is the same tree
+	builtKeys := make([][]byte, len(sorted))
+	cmds := make([]*Cmd, len(sorted))
+	for i, k := range sorted {
+		builtKeys[i], cmds[i] = []byte(k), index.get([]byte(k))
+	}
+	built := buildIndex(builtKeys, cmds)
+	if built.len() != index.len() || built.prio != index.prio || !bytes.Equal(built.key, index.key) {
+		t.Error("built index differ")
+	}
+	for _, pos := range []int{0, len(sorted) / 3, len(sorted) - 1} {
+		if n := built.at(pos); string(n.key) != sorted[pos] || n.cmd != cmds[pos] {
+			t.Error("wrong built at", pos)
+		}
+	}
+
+	// old root is frozen view
+	old := index
+	for _, k := range sorted[:100] {
+		index = index.remove([]byte(k))
+	}
+	index = index.put([]byte("new"), &Cmd{})
+	if old.len() != len(sorted) || old.get([]byte(sorted[0])) == nil || old.get([]byte("new")) != nil {
+		t.Error("old root changed")
+	}
+	if index.len() != len(sorted)-99 || index.get([]byte(sorted[0])) != nil {
+		t.Error("wrong new root")
+	}
+}
+
+func TestKeysOffset(t *testing.T) {
+	f := "tests/TestKeysOffset.db"
+	DeleteFile(f)
+	defer CloseAll()
+	var pairs [][]byte
+	for i := 0; i < 1000; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		pairs = append(pairs, k, k)
+	}
+	ch(Sets(f, pairs), t)
+	keys, _ := Keys(f, nil, 2, 500, true)
+	if len(keys) != 2 || string(keys[0]) != "0500" || string(keys[1]) != "0501" {
+		t.Error("wrong asc page", keys)
+	}
+	keys, _ = Keys(f, nil, 2, 500, false)
+	if len(keys) != 2 || string(keys[0]) != "0499" || string(keys[1]) != "0498" {
+		t.Error("wrong desc page", keys)
+	}
+	keys, _ = Keys(f, []byte("01*"), 3, 5, false)
+	if len(keys) != 3 || string(keys[0]) != "0194" || string(keys[2]) != "0192" {
+		t.Error("wrong desc prefix page", keys)
+	}
+	keys, _ = Keys(f, []byte("0998"), 0, 0, true)
+	if len(keys) != 1 || string(keys[0]) != "0999" {
+		t.Error("wrong keys after", keys)
+	}
+}
+
+func BenchmarkIndexPut(b *testing.B) {
+	r := rand.New(rand.NewSource(42))
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%015d", r.Int63()))
+	}
+	b.ResetTimer()
+	var index *node
+	for _, k := range keys {
+		index = index.put(k, nil)
+	}
+}
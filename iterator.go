@@ -0,0 +1,222 @@
+package gig
+
+import (
+	"bytes"
+	"iter"
+)
+
+// IterOptions - range of keys of Iterator, nil options - all keys
+type IterOptions struct {
+	// LowerBound - keys not less then it (nil - from first key)
+	LowerBound []byte
+	// UpperBound - keys less then it (nil - to last key)
+	UpperBound []byte
+	// Prefix - only keys with prefix, it narrow bounds
+	Prefix []byte
+}
+
+// Iterator walk keys of view in ascending or descending order, see NewIterator
+// Iterator is positioned by First, Last or Seek and moved by Next and Prev,
+// Next of not positioned iterator is First, Prev is Last
+// Keys are found by position in index, so Seek and moves does not scan keys before them
+// Iterator is not safe for concurrent use
+type Iterator struct {
+	view  *View
+	index *node
+	// own is true if view released by Close
+	own bool
+	// start and end - positions of range in index, end excluded
+	start, end int
+	// pos is start-1 or end if iterator is not valid
+	pos        int
+	positioned bool
+	n          *node
+	err        error
+}
+
+// NewIterator return iterator of snapshot of store, it must be closed by Close
+// Iterator see keys as they was at the moment of NewIterator, see Snapshot
+func NewIterator(file string, opts *IterOptions) (*Iterator, error) {
+	v, err := Snapshot(file)
+	if err != nil {
+		return nil, err
+	}
+	it := v.Iterator(opts)
+	it.own = true
+	return it, nil
+}
+
+// Iterator return iterator of keys of view, it is valid until view released
+func (v *View) Iterator(opts *IterOptions) *Iterator {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	it := &Iterator{view: v, index: v.index}
+	var lower, upper []byte
+	if opts != nil {
+		lower, upper = opts.LowerBound, opts.UpperBound
+		if opts.Prefix != nil {
+			if bytes.Compare(opts.Prefix, lower) > 0 {
+				lower = opts.Prefix
+			}
+			if end := prefixEnd(opts.Prefix); end != nil && (upper == nil || bytes.Compare(end, upper) < 0) {
+				upper = end
+			}
+		}
+	}
+	it.start = it.index.search(func(key []byte) bool {
+		return bytes.Compare(key, lower) < 0
+	})
+	it.end = it.index.len()
+	if upper != nil {
+		it.end = it.index.search(func(key []byte) bool {
+			return bytes.Compare(key, upper) < 0
+		})
+	}
+	if it.end < it.start {
+		it.end = it.start
+	}
+	return it
+}
+
+// prefixEnd return first key after all keys with prefix, nil if there is no such key
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// move set position and skip expired keys in direction step
+func (it *Iterator) move(pos, step int) bool {
+	it.positioned = true
+	for it.pos = pos; it.pos >= it.start && it.pos < it.end; it.pos += step {
+		it.n = it.index.at(it.pos)
+		if !it.n.cmd.expired(it.view.at) {
+			return true
+		}
+	}
+	if it.pos < it.start {
+		it.pos = it.start - 1
+	} else {
+		it.pos = it.end
+	}
+	it.n = nil
+	return false
+}
+
+// First move to first key of range, return false if range is empty
+func (it *Iterator) First() bool {
+	return it.move(it.start, 1)
+}
+
+// Last move to last key of range, return false if range is empty
+func (it *Iterator) Last() bool {
+	return it.move(it.end-1, -1)
+}
+
+// Seek move to first key of range not less then key, return false if there is no such key
+func (it *Iterator) Seek(key []byte) bool {
+	pos := it.index.search(func(k []byte) bool {
+		return bytes.Compare(k, key) < 0
+	})
+	if pos < it.start {
+		pos = it.start
+	}
+	return it.move(pos, 1)
+}
+
+// Next move to next key, return false after last key
+func (it *Iterator) Next() bool {
+	if !it.positioned {
+		return it.First()
+	}
+	if it.pos >= it.end {
+		return false
+	}
+	return it.move(it.pos+1, 1)
+}
+
+// Prev move to previous key, return false before first key
+func (it *Iterator) Prev() bool {
+	if !it.positioned {
+		return it.Last()
+	}
+	if it.pos < it.start {
+		return false
+	}
+	return it.move(it.pos-1, -1)
+}
+
+// Valid return true if iterator is positioned at key
+func (it *Iterator) Valid() bool {
+	return it.n != nil
+}
+
+// Key return current key or nil if iterator is not valid
+// Key must not be changed
+func (it *Iterator) Key() []byte {
+	if it.n == nil {
+		return nil
+	}
+	return it.n.key
+}
+
+// Value return value of current key
+// Return ErrKeyNotFound if iterator is not valid and ErrDbNotOpen if view released
+func (it *Iterator) Value() ([]byte, error) {
+	if it.n == nil {
+		return nil, ErrKeyNotFound
+	}
+	v := it.view
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.released {
+		return nil, ErrDbNotOpen
+	}
+	return readVal(v.fv, v.version, v.aead, it.n.key, it.n.cmd)
+}
+
+// All return sequence of keys and values from first key of range
+// Sequence stop on error of value, see Err
+func (it *Iterator) All() iter.Seq2[[]byte, []byte] {
+	return it.seq(it.First, it.Next)
+}
+
+// Backward return sequence of keys and values from last key of range
+// Sequence stop on error of value, see Err
+func (it *Iterator) Backward() iter.Seq2[[]byte, []byte] {
+	return it.seq(it.Last, it.Prev)
+}
+
+// seq return sequence of keys and values moved by first and next
+func (it *Iterator) seq(first, next func() bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for ok := first(); ok; ok = next() {
+			val, err := it.Value()
+			if err != nil {
+				it.err = err
+				return
+			}
+			if !yield(it.Key(), val) {
+				return
+			}
+		}
+	}
+}
+
+// Err return error of value which stop sequence of All or Backward
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close release view of iterator created by NewIterator
+func (it *Iterator) Close() {
+	it.n, it.pos, it.positioned = nil, it.end, true
+	if it.own {
+		it.view.Release()
+	}
+}
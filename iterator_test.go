@@ -0,0 +1,113 @@
+package gig
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIterator(t *testing.T) {
+	f := "tests/TestIterator.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for _, k := range []string{"a", "a*", "a*b", "ab", "b", "b\xff", "b\xff\xff", "c"} {
+		ch(Set(f, []byte(k), []byte("v"+k)), t)
+	}
+	ch(SetWithTTL(f, []byte("aa"), []byte("expired"), time.Millisecond), t)
+	time.Sleep(5 * time.Millisecond)
+
+	collect := func(opts *IterOptions, backward bool) string {
+		it, err := NewIterator(f, opts)
+		ch(err, t)
+		defer it.Close()
+		seq := it.All()
+		if backward {
+			seq = it.Backward()
+		}
+		var keys []string
+		for k, v := range seq {
+			if string(v) != "v"+string(k) {
+				t.Error("not equal", string(k), string(v))
+			}
+			keys = append(keys, string(k))
+		}
+		ch(it.Err(), t)
+		return strings.Join(keys, ",")
+	}
+	for _, c := range []struct {
+		opts     *IterOptions
+		backward bool
+		keys     string
+	}{
+		{nil, false, "a,a*,a*b,ab,b,b\xff,b\xff\xff,c"},
+		{nil, true, "c,b\xff\xff,b\xff,b,ab,a*b,a*,a"},
+		{&IterOptions{Prefix: []byte("a*")}, false, "a*,a*b"},
+		{&IterOptions{Prefix: []byte("a")}, true, "ab,a*b,a*,a"},
+		{&IterOptions{Prefix: []byte("b\xff")}, false, "b\xff,b\xff\xff"},
+		{&IterOptions{LowerBound: []byte("a*"), UpperBound: []byte("b")}, false, "a*,a*b,ab"},
+		{&IterOptions{LowerBound: []byte("ab"), UpperBound: []byte("a")}, false, ""},
+		{&IterOptions{LowerBound: []byte("a*b"), Prefix: []byte("a")}, false, "a*b,ab"},
+		{&IterOptions{Prefix: []byte("d")}, true, ""},
+	} {
+		if keys := collect(c.opts, c.backward); keys != c.keys {
+			t.Errorf("wrong keys %q, want %q", keys, c.keys)
+		}
+	}
+
+	it, err := NewIterator(f, &IterOptions{LowerBound: []byte("a*"), UpperBound: []byte("c")})
+	ch(err, t)
+	// writes after iterator created are not visible
+	ch(Set(f, []byte("a*a"), []byte("new")), t)
+	step := func(ok bool, key string) {
+		if key == "" && (ok || it.Valid()) || key != "" && (!ok || string(it.Key()) != key) {
+			t.Errorf("wrong position %q, want %q", it.Key(), key)
+		}
+	}
+	step(it.Prev(), "b\xff\xff")
+	step(it.Seek([]byte("a*")), "a*")
+	step(it.Next(), "a*b")
+	step(it.Prev(), "a*")
+	step(it.Prev(), "")
+	step(it.Prev(), "")
+	step(it.Next(), "a*")
+	step(it.Seek([]byte("a")), "a*")
+	step(it.Seek([]byte("aa")), "ab")
+	step(it.Seek([]byte("z")), "")
+	step(it.Prev(), "b\xff\xff")
+	step(it.Last(), "b\xff\xff")
+	step(it.Next(), "")
+	step(it.Next(), "")
+	step(it.First(), "a*")
+	if v, err := it.Value(); err != nil || string(v) != "va*" {
+		t.Error("not equal", string(v), err)
+	}
+	it.Close()
+	if _, err = it.Value(); err != ErrKeyNotFound {
+		t.Error("value of closed iterator", err)
+	}
+
+	// pages by seek
+	DeleteFile(f)
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%03d", i))
+		ch(Set(f, k, k), t)
+	}
+	it, err = NewIterator(f, nil)
+	ch(err, t)
+	defer it.Close()
+	var next []byte
+	for page := 0; page < 10; page++ {
+		n := 0
+		for ok := it.Seek(next); ok && n < 10; ok = it.Next() {
+			if string(it.Key()) != fmt.Sprintf("%03d", page*10+n) {
+				t.Error("wrong key", string(it.Key()))
+			}
+			n++
+		}
+		next = it.Key()
+	}
+	if next != nil {
+		t.Error("keys after last page", string(next))
+	}
+}
@@ -0,0 +1,48 @@
+package gig
+
+import (
+	"os"
+	"time"
+)
+
+// Store opened for writing by one process only, process hold exclusive advisory lock of lock file
+// while store opened. Processes which opened store read-only hold shared lock of other lock file,
+// so writer know about them, see shared
+// Lock files are not swapped by compaction, so lock is held until Close
+
+// lockRetry - interval of tries to lock file locked by other process
+const lockRetry = 10 * time.Millisecond
+
+// lockStore open and lock lock file of store, many processes may hold shared lock
+// Return ErrLocked if file is still locked by other process after timeout
+func lockStore(path string, shared bool, timeout time.Duration) (*os.File, error) {
+	fl, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, FILE_MODE)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(fl, shared)
+		if err != nil {
+			fl.Close()
+			return nil, err
+		}
+		if ok {
+			return fl, nil
+		}
+		if !time.Now().Before(deadline) {
+			fl.Close()
+			return nil, ErrLocked
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// unlockStore release lock of store and close lock file
+func unlockStore(fl *os.File) error {
+	if fl == nil {
+		return nil
+	}
+	unlock(fl)
+	return fl.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package gig
+
+import "os"
+
+// tryLock always succeed, files are not locked on this platform
+func tryLock(f *os.File, shared bool) (bool, error) {
+	return true, nil
+}
+
+// unlock do nothing, files are not locked on this platform
+func unlock(f *os.File) error {
+	return nil
+}
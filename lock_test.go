@@ -0,0 +1,42 @@
+package gig
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	f := "tests/TestLock.db"
+	DeleteFile(f)
+	defer CloseAll()
+	db, err := Open(f)
+	ch(err, t)
+	ch(Set(f, []byte("foo"), []byte("bar")), t)
+
+	// lock of file is owned by open file, so second open of files act like other process
+	if _, err = newDB(f, Options{}); err != ErrLocked {
+		t.Error("opened locked store", err)
+	}
+	start := time.Now()
+	if _, err = newDB(f, Options{LockTimeout: 50 * time.Millisecond}); err != ErrLocked {
+		t.Error("opened locked store", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("not waited for lock")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		db.Close()
+	}()
+	other, err := newDB(f, Options{LockTimeout: 5 * time.Second})
+	ch(err, t)
+	if v, err := other.readKey("foo"); err != nil || string(v) != "bar" {
+		t.Error("not equal", string(v), err)
+	}
+	ch(other.Close(), t)
+
+	// lock released on close
+	_, err = Open(f)
+	ch(err, t)
+}
@@ -0,0 +1,161 @@
+package gig
+
+import "encoding/binary"
+
+// LZ codec is block format like LZ4:
+// uvarint(size of value) then sequences of
+// token(1) [literals length] literals offset(2) [match length]
+// High 4 bits of token is count of literals, low 4 bits is length of match minus lzMinMatch,
+// value 15 of them continued by bytes of length until byte less then 255.
+// Offset is little endian distance back to match. Last sequence has literals only.
+
+const (
+	lzMinMatch  = 4
+	lzHashLog   = 14
+	lzMaxOffset = 1<<16 - 1
+	// lzLastLiterals - last bytes always stored as literals, so match search never read after end
+	lzLastLiterals = 5
+)
+
+// lzHash return position in hash table for 4 bytes
+func lzHash(v uint32) uint32 {
+	return v * 2654435761 >> (32 - lzHashLog)
+}
+
+// lzPutLen append rest of length after 15 in token
+func lzPutLen(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lzEncode compress src, every match found by hash of 4 bytes
+func lzEncode(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2+16)
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	var table [1 << lzHashLog]int32
+	anchor := 0
+	limit := len(src) - lzLastLiterals - lzMinMatch
+	for i := 0; i < limit; {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := lzHash(v)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lzMaxOffset || binary.LittleEndian.Uint32(src[ref:]) != v {
+			i++
+			continue
+		}
+		// extend match forward
+		n := lzMinMatch
+		for i+n < len(src)-lzLastLiterals && src[ref+n] == src[i+n] {
+			n++
+		}
+		dst = lzSequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+	return lzSequence(dst, src[anchor:], 0, 0)
+}
+
+// lzSequence append literals and match, match with length 0 is end of block
+func lzSequence(dst, literals []byte, offset, n int) []byte {
+	var token byte
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	if n > 0 {
+		if n-lzMinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(n - lzMinMatch)
+		}
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lzPutLen(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if n == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if n-lzMinMatch >= 15 {
+		dst = lzPutLen(dst, n-lzMinMatch-15)
+	}
+	return dst
+}
+
+// lzGetLen read rest of length after 15 in token
+func lzGetLen(src []byte, i int) (int, int, error) {
+	n := 0
+	for {
+		if i >= len(src) {
+			return 0, i, ErrCorrupted
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
+
+// lzDecode decompress src written by lzEncode
+// Return ErrCorrupted if src is damaged
+func lzDecode(src []byte) ([]byte, error) {
+	size, i := binary.Uvarint(src)
+	if i <= 0 || size > uint64(len(src))*255 {
+		return nil, ErrCorrupted
+	}
+	dst := make([]byte, 0, size)
+	var err error
+	for i < len(src) {
+		token := src[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			var n int
+			if n, i, err = lzGetLen(src, i); err != nil {
+				return nil, err
+			}
+			literals += n
+		}
+		if literals > len(src)-i || len(dst)+literals > int(size) {
+			return nil, ErrCorrupted
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, ErrCorrupted
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		n := int(token&15) + lzMinMatch
+		if token&15 == 15 {
+			var more int
+			if more, i, err = lzGetLen(src, i); err != nil {
+				return nil, err
+			}
+			n += more
+		}
+		if offset == 0 || offset > len(dst) || len(dst)+n > int(size) {
+			return nil, ErrCorrupted
+		}
+		// match may overlap bytes it copy, so copy byte by byte
+		start := len(dst) - offset
+		for j := 0; j < n; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, ErrCorrupted
+	}
+	return dst, nil
+}
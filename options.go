@@ -0,0 +1,113 @@
+package gig
+
+import (
+	"bytes"
+	"time"
+)
+
+// SyncMode - when written records are synced to disk
+type SyncMode uint8
+
+const (
+	// SYNC_ALWAYS - every write synced before it return (default)
+	SYNC_ALWAYS SyncMode = iota
+	// SYNC_INTERVAL - writes synced every SyncInterval and every SyncWrites writes
+	SYNC_INTERVAL
+	// SYNC_NONE - writes synced by OS, on Sync and on Close
+	SYNC_NONE
+)
+
+// Options of store, see OpenWithOptions
+// Records written after last sync may be lost on crash of OS or power loss,
+// key record may be on disk while its value not, such value read as ErrCorrupted
+type Options struct {
+	// Sync - when writes synced to disk
+	Sync SyncMode
+	// SyncInterval - max time between syncs in mode SYNC_INTERVAL (0 - not used)
+	SyncInterval time.Duration
+	// SyncWrites - max count of writes between syncs in mode SYNC_INTERVAL (0 - not used)
+	SyncWrites int
+	// Compression - codec of new values, values of stores of version 0 never compressed
+	Compression Codec
+	// CompressMinSize - values smaller then this size stored as is
+	// (0 - DEFAULT_COMPRESS_MIN_SIZE, use 1 to compress all values)
+	CompressMinSize int
+	// Key - AES key of 16, 24 or 32 bytes, values sealed by it (nil - values not sealed)
+	// Store sealed by key can not be opened without it, see Rotate
+	Key []byte
+	// LockTimeout - max time to wait for store locked by other process (0 - return ErrLocked at once)
+	// It is used only on open of files, so it is not compared with options of opened store
+	LockTimeout time.Duration
+	// RefreshInterval - store opened read-only is refreshed every interval (0 - only by Refresh)
+	RefreshInterval time.Duration
+	// readOnly is set by OpenReadOnly
+	readOnly bool
+	// replica is set by Follow, store is written only by follower
+	replica bool
+}
+
+// DEFAULT_SYNC_INTERVAL - used in mode SYNC_INTERVAL if no limits set
+const DEFAULT_SYNC_INTERVAL = time.Second
+
+// options return options with defaults
+func (o *Options) options() Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.Sync == SYNC_INTERVAL && opts.SyncInterval <= 0 && opts.SyncWrites <= 0 {
+		opts.SyncInterval = DEFAULT_SYNC_INTERVAL
+	}
+	if opts.CompressMinSize <= 0 {
+		opts.CompressMinSize = DEFAULT_COMPRESS_MIN_SIZE
+	}
+	return opts
+}
+
+// equal return true if options are the same
+func (o Options) equal(other Options) bool {
+	return o.Sync == other.Sync && o.SyncInterval == other.SyncInterval && o.SyncWrites == other.SyncWrites &&
+		o.Compression == other.Compression && o.CompressMinSize == other.CompressMinSize &&
+		bytes.Equal(o.Key, other.Key) && o.RefreshInterval == other.RefreshInterval && o.readOnly == other.readOnly &&
+		o.replica == other.replica
+}
+
+// syncAlways return true if every write must be synced
+func (s *store) syncAlways() bool {
+	return s.opts.Sync == SYNC_ALWAYS
+}
+
+// written count write and sync files if sync mode require it
+// Changes of write are sent to watchers, so they get events before write return
+func (s *store) written() error {
+	defer s.notify()
+	s.unsynced++
+	switch s.opts.Sync {
+	case SYNC_ALWAYS:
+		return s.sync()
+	case SYNC_INTERVAL:
+		if s.opts.SyncWrites > 0 && s.unsynced >= s.opts.SyncWrites {
+			return s.sync()
+		}
+	}
+	return nil
+}
+
+// sync write to disk all writes after last sync
+// Values file synced first, so synced key record never point to lost value
+func (s *store) sync() error {
+	if s.unsynced == 0 && !s.valDirty {
+		return nil
+	}
+	if s.valDirty {
+		if err := s.fv.Sync(); err != nil {
+			return err
+		}
+		s.valDirty = false
+	}
+	if err := s.fk.Sync(); err != nil {
+		return err
+	}
+	s.unsynced = 0
+	return nil
+}
@@ -0,0 +1,72 @@
+package gig
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSyncModes(t *testing.T) {
+	f := "tests/TestSyncModes.db"
+	DeleteFile(f)
+	defer CloseAll()
+	db, err := OpenWithOptions(f, &Options{Sync: SYNC_INTERVAL, SyncWrites: 3})
+	ch(err, t)
+	if _, err = OpenWithOptions(f, &Options{Sync: SYNC_NONE}); err != ErrDbOpened {
+		t.Error("opened with other options", err)
+	}
+	if other, err := Open(f); err != nil || other != db {
+		t.Error("store not shared", err)
+	}
+	ch(Set(f, []byte("1"), []byte("1")), t)
+	ch(Set(f, []byte("2"), []byte("2")), t)
+	if db.s.unsynced != 2 || !db.s.valDirty {
+		t.Error("writes synced", db.s.unsynced)
+	}
+	ch(Set(f, []byte("3"), []byte("3")), t)
+	if db.s.unsynced != 0 || db.s.valDirty {
+		t.Error("writes not synced", db.s.unsynced)
+	}
+	ch(Set(f, []byte("4"), []byte("4")), t)
+	ch(Sync(f), t)
+	if db.s.unsynced != 0 || db.s.valDirty {
+		t.Error("writes not synced", db.s.unsynced)
+	}
+	Close(f)
+
+	// writes flushed on close
+	_, err = OpenWithOptions(f, &Options{Sync: SYNC_NONE})
+	ch(err, t)
+	var pairs [][]byte
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, k), t)
+		pairs = append(pairs, k, k)
+	}
+	ch(Sets(f, pairs), t)
+	Delete(f, []byte("0000"))
+	Close(f)
+	if cnt, _ := Count(f); cnt != 103 {
+		t.Error("wrong count", cnt)
+	}
+	if v, err := Get(f, []byte("0099")); err != nil || string(v) != "0099" {
+		t.Error("not equal", string(v), err)
+	}
+}
+
+func BenchmarkSetSyncNone(b *testing.B) {
+	f := "tests/BenchmarkSetSyncNone.db"
+	DeleteFile(f)
+	defer CloseAll()
+	if _, err := OpenWithOptions(f, &Options{Sync: SYNC_NONE}); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := []byte(fmt.Sprintf("%010d", i))
+		if err := Set(f, k, k); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	Sync(f)
+}
@@ -0,0 +1,71 @@
+package gig
+
+import (
+	"bytes"
+)
+
+// rangeKeys return keys from start to end (end excluded, empty end - to last key)
+func (s *store) rangeKeys(start, end []byte) [][]byte {
+	var keys [][]byte
+	if s.loading {
+		// index is not built yet
+		for key := range s.valDict {
+			if bytes.Compare([]byte(key), start) >= 0 && (len(end) == 0 || bytes.Compare([]byte(key), end) < 0) {
+				keys = append(keys, []byte(key))
+			}
+		}
+	} else {
+		from := s.index.search(func(key []byte) bool {
+			return bytes.Compare(key, start) < 0
+		})
+		s.index.ascend(from, func(n *node) bool {
+			if len(end) > 0 && bytes.Compare(n.key, end) >= 0 {
+				return false
+			}
+			keys = append(keys, n.key)
+			return true
+		})
+	}
+	return keys
+}
+
+// delRange delete keys from start to end (end excluded, empty end - to last key)
+// Return count of deleted keys
+// It must be called under lock if store goroutine is started
+func (s *store) delRange(start, end []byte) int {
+	keys := s.rangeKeys(start, end)
+	for _, key := range keys {
+		s.delCmd(key)
+	}
+	return len(keys)
+}
+
+// deleteRange delete keys of range from index at once and write one range delete record
+// Record is not written if there is no keys in range, keys deleted from index only after record is written
+// Version 0 has no range delete record, so store must be upgraded
+func (s *store) deleteRange(start, end []byte) (int, error) {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return 0, nil
+	}
+	if s.version == 0 {
+		return 0, ErrNeedUpgrade
+	}
+	// index is changed only by store goroutine, so keys are the same after write
+	keys := s.rangeKeys(start, end)
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	key := append(append([]byte{}, start...), end...)
+	rec := &keyRecord{version: s.version, cmd: cmdDeleteRange, size: uint32(len(start)), key: key}
+	seek, err := writeKey(s.fk, rec, false)
+	if err != nil {
+		return 0, err
+	}
+	s.grow(seek, 0, int(keyRecordSize(s.version, cmdDeleteRange, key)), 0)
+	s.mu.Lock()
+	for _, k := range keys {
+		s.delCmd(k)
+	}
+	s.mu.Unlock()
+	return len(keys), s.written()
+}
@@ -0,0 +1,108 @@
+package gig
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	f := "tests/TestDeleteRange.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for _, term := range []string{"1", "2", "10"} {
+		for i := 0; i < 10; i++ {
+			k := []byte(fmt.Sprintf("terminal:%s:%d", term, i))
+			ch(Set(f, k, k), t)
+		}
+	}
+	ch(Set(f, []byte("a"), []byte("a")), t)
+	ch(Set(f, []byte("z"), []byte("z")), t)
+	ch(Checkpoint(f), t)
+	view, err := Snapshot(f)
+	ch(err, t)
+	defer view.Release()
+
+	if n, err := DeletePrefix(f, []byte("terminal:1:")); err != nil || n != 10 {
+		t.Error("wrong count", n, err)
+	}
+	db, _ := Open(f)
+	size := db.s.keySize
+	if n, err := DeletePrefix(f, []byte("terminal:1:")); err != nil || n != 0 || db.s.keySize != size {
+		t.Error("empty range written", n, err)
+	}
+	if n, err := DeleteRange(f, []byte("terminal:2:5"), []byte("terminal:2:8")); err != nil || n != 3 {
+		t.Error("wrong count", n, err)
+	}
+	if n, err := DeleteRange(f, []byte("b"), []byte("a")); err != nil || n != 0 {
+		t.Error("wrong count", n, err)
+	}
+	// key set after range delete is not deleted on replay
+	ch(Set(f, []byte("terminal:1:0"), []byte("new")), t)
+
+	check := func() {
+		if cnt, _ := Count(f); cnt != 20 {
+			t.Error("wrong count", cnt)
+		}
+		for _, k := range []string{"terminal:1:5", "terminal:2:5", "terminal:2:7"} {
+			if has, _ := Has(f, []byte(k)); has {
+				t.Error("not deleted", k)
+			}
+		}
+		for _, k := range []string{"terminal:10:5", "terminal:2:4", "terminal:2:8", "a", "z"} {
+			if has, _ := Has(f, []byte(k)); !has {
+				t.Error("deleted", k)
+			}
+		}
+		if v, err := Get(f, []byte("terminal:1:0")); err != nil || string(v) != "new" {
+			t.Error("not equal", string(v), err)
+		}
+	}
+	check()
+	if view.Count() != 32 {
+		t.Error("view changed", view.Count())
+	}
+
+	// replay after checkpoint and from start
+	Close(f)
+	check()
+	Close(f)
+	os.Remove(f + CHECKPOINT_FILE_EXT)
+	check()
+	r, err := Check(f)
+	ch(err, t)
+	if !r.Ok() || r.Deletes != 2 {
+		t.Error("wrong report", r.Deletes, r.Problems)
+	}
+
+	_, err = Compact(f)
+	ch(err, t)
+	Close(f)
+	check()
+	if n, err := DeleteRange(f, nil, nil); err != nil || n != 20 {
+		t.Error("wrong count", n, err)
+	}
+	Close(f)
+	if cnt, _ := Count(f); cnt != 0 {
+		t.Error("wrong count", cnt)
+	}
+}
+
+func TestDeleteRangeFormatV0(t *testing.T) {
+	f := "tests/TestDeleteRangeFormatV0.db"
+	createV0(t, f, 3)
+	defer CloseAll()
+	db, err := Open(f)
+	ch(err, t)
+	size := db.s.keySize
+	if n, err := DeletePrefix(f, nil); err != ErrNeedUpgrade || n != 0 || db.s.keySize != size {
+		t.Error("range delete in version 0", n, err)
+	}
+	if cnt, _ := Count(f); cnt != 3 {
+		t.Error("keys deleted", cnt)
+	}
+	ch(Upgrade(f), t)
+	if n, err := DeletePrefix(f, nil); err != nil || n != 3 {
+		t.Error("wrong count", n, err)
+	}
+}
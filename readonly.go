@@ -0,0 +1,134 @@
+package gig
+
+import (
+	"os"
+	"time"
+)
+
+// Store opened read-only never write its files, index is built like on open and then
+// updated by records appended by writer process, see refresh
+// Writer append values while store opened read-only by other processes, so they are never
+// overwritten in place. If writer compact store, files are renamed and read-only store
+// open new files on refresh, old values are read from old files until that
+
+// openTries - count of tries to open files of store, which are swapped by compaction of writer
+const openTries = 100
+
+// openReadOnly lock store by shared lock and open its files for reading
+func (s *store) openReadOnly() (err error) {
+	if s.fl, err = lockStore(s.file+READ_LOCK_FILE_EXT, true, s.opts.LockTimeout); err != nil {
+		return err
+	}
+	if s.fk, s.fv, s.version, s.generation, err = openReadFiles(s.file); err != nil {
+		return err
+	}
+	s.aead, err = openCipher(s.fv, s.version, s.opts.Key, true)
+	return err
+}
+
+// openReadFiles open files of store for reading
+// Values file renamed first by compaction, so files of different generations are opened again
+func openReadFiles(file string) (fk, fv *os.File, version uint8, generation uint64, err error) {
+	for try := 0; try < openTries; try++ {
+		if fk, err = os.Open(file + KEY_FILE_EXT); err != nil {
+			return
+		}
+		if fv, err = os.Open(file + VAL_FILE_EXT); err != nil {
+			fk.Close()
+			return
+		}
+		if version, err = detectVersion(fk, fv); err == nil && version > 0 {
+			var valGeneration uint64
+			if generation, err = readGeneration(fk); err == nil {
+				valGeneration, err = readGeneration(fv)
+			}
+			if err == nil && generation != valGeneration {
+				fk.Close()
+				fv.Close()
+				time.Sleep(lockRetry)
+				continue
+			}
+		}
+		if err != nil {
+			fk.Close()
+			fv.Close()
+		}
+		return
+	}
+	return nil, nil, 0, 0, ErrLocked
+}
+
+// shared return true if store is opened read-only by other process
+func (s *store) shared() bool {
+	if s.fr == nil {
+		return false
+	}
+	ok, err := tryLock(s.fr, false)
+	if err != nil || !ok {
+		return true
+	}
+	unlock(s.fr)
+	return false
+}
+
+// refresh apply records appended by writer after last refresh
+// Last record may be written just now or batch may be not committed yet,
+// so reading stop before them and continue on next refresh
+func (s *store) refresh() error {
+	if !s.readOnly {
+		return nil
+	}
+	info, err := os.Stat(s.file + KEY_FILE_EXT)
+	if err != nil {
+		return err
+	}
+	opened, err := s.fk.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, opened) {
+		return s.reopen()
+	}
+	if opened.Size() <= s.keySize {
+		return nil
+	}
+	b := make([]byte, opened.Size()-s.keySize)
+	if _, err = s.fk.ReadAt(b, s.keySize); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	end, _ := s.load(b, int(s.keySize), nil)
+	s.mu.Unlock()
+	s.keySize = int64(end)
+	if info, err := s.fv.Stat(); err == nil {
+		s.valSize = info.Size()
+	}
+	return nil
+}
+
+// reopen load index from files swapped by compaction of writer
+// Index loaded without lock, then old files are replaced
+func (s *store) reopen() error {
+	n := &store{file: s.file, opts: s.opts, readOnly: true}
+	var err error
+	if n.fk, n.fv, n.version, n.generation, err = openReadFiles(s.file); err != nil {
+		return err
+	}
+	if n.aead, err = openCipher(n.fv, n.version, s.opts.Key, true); err == nil {
+		err = n.replay()
+	}
+	if err != nil {
+		n.closeFiles()
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fk.Close()
+	// old values may be read by views
+	s.closeVal(s.fv)
+	s.fk, s.fv, s.version, s.generation, s.aead = n.fk, n.fv, n.version, n.generation, n.aead
+	s.valDict, s.index, s.countersDict, s.expires = n.valDict, n.index, n.countersDict, n.expires
+	s.keySize, s.valSize, s.liveKey, s.liveVal = n.keySize, n.valSize, n.liveKey, n.liveVal
+	s.checkpointed = n.checkpointed
+	return nil
+}
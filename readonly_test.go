@@ -0,0 +1,105 @@
+package gig
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	f := "tests/TestReadOnly.db"
+	DeleteFile(f)
+	defer CloseAll()
+	_, err := Open(f)
+	ch(err, t)
+	ch(Set(f, []byte("a"), []byte("aaaa")), t)
+	ch(Set(f, []byte("b"), []byte("bbbb")), t)
+
+	// reader of other process, locks of files act like in other process
+	r, err := newDB(f, Options{readOnly: true})
+	ch(err, t)
+	defer r.Close()
+	if v, err := r.readKey("a"); err != nil || string(v) != "aaaa" {
+		t.Error("not equal", string(v), err)
+	}
+	if err = r.setKey("c", []byte("c"), 0); err != ErrReadOnly {
+		t.Error("set on read-only store", err)
+	}
+	if err = r.deleteKey("a"); err != ErrReadOnly {
+		t.Error("delete on read-only store", err)
+	}
+	if _, err = r.compact(compactRequest{}); err != ErrReadOnly {
+		t.Error("compact on read-only store", err)
+	}
+
+	// value is not overwritten in place while reader use it
+	ch(Set(f, []byte("a"), []byte("AAAA")), t)
+	ch(Set(f, []byte("c"), []byte("cccc")), t)
+	if v, err := r.readKey("a"); err != nil || string(v) != "aaaa" {
+		t.Error("value overwritten", string(v), err)
+	}
+	if has, _ := r.has("c"); has {
+		t.Error("key visible before refresh")
+	}
+	ch(r.refresh(), t)
+	if v, err := r.readKey("a"); err != nil || string(v) != "AAAA" {
+		t.Error("not refreshed", string(v), err)
+	}
+	if v, err := r.readKey("c"); err != nil || string(v) != "cccc" {
+		t.Error("not refreshed", string(v), err)
+	}
+
+	// batch and delete
+	batch := &WriteBatch{}
+	batch.Put([]byte("d"), []byte("dddd"))
+	batch.Delete([]byte("b"))
+	ch(Write(f, batch), t)
+	ch(r.refresh(), t)
+	if b, _ := r.has("b"); b {
+		t.Error("batch not refreshed")
+	}
+	if d, _ := r.has("d"); !d {
+		t.Error("batch not refreshed")
+	}
+
+	// files swapped by compaction
+	_, err = Compact(f)
+	ch(err, t)
+	ch(Set(f, []byte("e"), []byte("eeee")), t)
+	ch(r.refresh(), t)
+	if keys, err := r.readKeys(nil, 0, 0, true); err != nil || len(keys) != 4 {
+		t.Error("not reopened", len(keys), err)
+	}
+	if v, err := r.readKey("e"); err != nil || string(v) != "eeee" {
+		t.Error("not reopened", string(v), err)
+	}
+	ch(Close(f), t)
+	ch(r.Close(), t)
+
+	// files are not written by reader
+	infoKey, _ := os.Stat(f + KEY_FILE_EXT)
+	infoVal, _ := os.Stat(f + VAL_FILE_EXT)
+	db, err := OpenReadOnly(f, nil)
+	ch(err, t)
+	if other, err := Open(f); err != nil || other != db {
+		t.Error("store not shared", err)
+	}
+	if err = Set(f, []byte("f"), []byte("f")); err != ErrReadOnly {
+		t.Error("set on read-only store", err)
+	}
+	if _, err = Counter(f, []byte("counter")); err != ErrReadOnly {
+		t.Error("counter on read-only store", err)
+	}
+	if _, err = OpenWithOptions(f, &Options{}); err != ErrDbOpened {
+		t.Error("opened for writing", err)
+	}
+	if cnt, err := Count(f); err != nil || cnt != 4 {
+		t.Error("wrong count", cnt, err)
+	}
+	ch(Close(f), t)
+	if info, _ := os.Stat(f + KEY_FILE_EXT); info.Size() != infoKey.Size() || info.ModTime() != infoKey.ModTime() {
+		t.Error("keys file written")
+	}
+	if info, _ := os.Stat(f + VAL_FILE_EXT); info.Size() != infoVal.Size() || info.ModTime() != infoVal.ModTime() {
+		t.Error("values file written")
+	}
+}
@@ -0,0 +1,565 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Primary serve its changes to followers by Serve, follower apply them to its store, see Follow
+// Follower connect and send hello: magic(4) REPLICATION_VERSION(1) seq(8)
+// seq is sequence number of last change applied by follower, 0 - follower need snapshot
+// Primary answer by frames: type(1) size(4) payload CRC(4)
+// If seq is 0 or changes after it compacted, primary send snapshot:
+// frameSnapshot with seq(8) of snapshot, frameKeys with keys and values, frameSnapshotEnd
+// After it primary send frameChanges with changes after seq, as soon as they written
+// Frame is flushed when its payload reach frameBudget, so big batch of changes is sent by
+// frameChangesPart frames ended by frameChanges and applied at once by follower
+// Payload of frameKeys and frameChanges is list of changes:
+// seq(8) op(1) keySize(uvarint) key, set: hasValue(1) [valSize(uvarint) value] expire(varint),
+// range delete: endSize(uvarint) end
+// Values sent opened, so replica may have other options of compression and encryption
+
+// REPLICATION_VERSION - version of protocol of Serve and Follow
+const REPLICATION_VERSION = 1
+
+// helloTimeout - primary close connection if follower does not send hello in time
+const helloTimeout = 10 * time.Second
+
+// maxFrameItem - max average size of change in frame, see maxFrame
+const maxFrameItem = 256 * 1024
+
+// frameSize - frame is flushed when its payload reach this size, see frameBudget
+const frameSize = 1 << 20
+
+const (
+	frameSnapshot = iota + 1
+	frameKeys
+	frameSnapshotEnd
+	frameChanges
+	frameChangesPart
+)
+
+var (
+	replicationMagic = []byte("GIGR")
+
+	// ReplicationBatch - max count of changes sent to follower in one frame,
+	// batch is not split, so frame may have more changes
+	// Follower reject frame bigger then ReplicationBatch*256 KiB, see maxFrame
+	ReplicationBatch = 1024
+	// FollowRetry - follower connect again after this time, when connection failed
+	FollowRetry = time.Second
+	// FollowSaveInterval - position of follower saved not often then this time, see Follow
+	FollowSaveInterval = time.Second
+)
+
+// Serve send changes of store to followers connected to listener, see Follow
+// Store must be opened with version 2 of format, see Upgrade
+// Serve return error of listener, when it closed. Followers are served until they
+// disconnected or store closed. Follower is disconnected if change is bigger then its frame,
+// see ReplicationBatch
+func Serve(file string, l net.Listener) error {
+	db, err := Open(file)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go db.serve(conn)
+	}
+}
+
+// serve send snapshot and changes to follower until follower disconnected or store closed
+func (db *DB) serve(conn net.Conn) {
+	defer conn.Close()
+	hello := make([]byte, len(replicationMagic)+1+8)
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if !bytes.Equal(hello[:len(replicationMagic)], replicationMagic) || hello[len(replicationMagic)] != REPLICATION_VERSION {
+		return
+	}
+	seq := binary.BigEndian.Uint64(hello[len(replicationMagic)+1:])
+	// watch started before first read of changes, so no change is missed
+	events, cancel, err := db.watch(nil)
+	if err != nil {
+		return
+	}
+	defer cancel()
+	// follower send nothing after hello, so read end when it disconnected
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	w := bufio.NewWriter(conn)
+	fw := &frameWriter{w: w}
+	for {
+		var changes []Change
+		if seq != 0 {
+			changes, _, err = db.changes(seq, ReplicationBatch)
+		}
+		if seq == 0 || err == ErrCompacted {
+			seq, err = db.sendSnapshot(w)
+		} else if err == nil && len(changes) > 0 {
+			seq = changes[len(changes)-1].Seq
+			// batch of primary may be bigger then frame, so only the last frame applies it
+			for _, c := range changes {
+				if err = fw.add(frameChangesPart, c); err != nil {
+					return
+				}
+			}
+			if err = fw.flush(frameChanges); err != nil {
+				return
+			}
+			continue
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+			// all buffered events are served by one read of changes
+			for len(events) > 0 {
+				<-events
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// sendSnapshot write frames of snapshot of store, return sequence number of snapshot
+func (db *DB) sendSnapshot(w io.Writer) (uint64, error) {
+	v, err := db.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer v.Release()
+	if v.version < 2 {
+		return 0, ErrNeedUpgrade
+	}
+	if err = writeFrame(w, frameSnapshot, binary.BigEndian.AppendUint64(nil, v.seq)); err != nil {
+		return 0, err
+	}
+	it := v.Iterator(nil)
+	fw := &frameWriter{w: w}
+	for ok := it.First(); ok; ok = it.Next() {
+		val, err := it.Value()
+		if err != nil {
+			return 0, err
+		}
+		c := Change{Seq: v.seq, Op: EVENT_SET, Key: it.Key(), Value: val, Expire: it.n.cmd.Expire}
+		if err = fw.add(frameKeys, c); err != nil {
+			return 0, err
+		}
+		if fw.count == ReplicationBatch {
+			if err = fw.flush(frameKeys); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if fw.count > 0 {
+		if err = fw.flush(frameKeys); err != nil {
+			return 0, err
+		}
+	}
+	return v.seq, writeFrame(w, frameSnapshotEnd, nil)
+}
+
+// frameBudget return size of payload, after which frame is flushed
+// It is below maxFrame, so change is sent in frame of its own, if it does not fit in budget
+func frameBudget() int {
+	return int(min(frameSize, maxFrame()/2))
+}
+
+// frameWriter split changes to frames by frameBudget
+type frameWriter struct {
+	w io.Writer
+	// b - payload of changes not written yet, count - count of them
+	b     []byte
+	count int
+}
+
+// add append change to payload, frame of type t is written before change if change does not fit in it
+func (fw *frameWriter) add(t byte, c Change) error {
+	n := len(fw.b)
+	fw.b = appendChange(fw.b, c)
+	fw.count++
+	if n == 0 || len(fw.b) <= frameBudget() {
+		return nil
+	}
+	if err := writeFrame(fw.w, t, fw.b[:n]); err != nil {
+		return err
+	}
+	fw.b = append(fw.b[:0], fw.b[n:]...)
+	fw.count = 1
+	return nil
+}
+
+// flush write frame of type t with added changes
+func (fw *frameWriter) flush(t byte) error {
+	err := writeFrame(fw.w, t, fw.b)
+	fw.b, fw.count = fw.b[:0], 0
+	return err
+}
+
+// writeFrame write frame with type t and checksum of payload
+// Return ErrFrameTooBig if follower can not read payload, see maxFrame
+func writeFrame(w io.Writer, t byte, payload []byte) error {
+	if int64(len(payload)) > min(maxFrame(), math.MaxUint32) {
+		return ErrFrameTooBig
+	}
+	b := make([]byte, 0, 1+4+len(payload)+4)
+	b = append(b, t)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = append(b, payload...)
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(payload, crcTable))
+	_, err := w.Write(b)
+	return err
+}
+
+// maxFrame return max size of payload of frame, so broken size does not allocate much memory
+func maxFrame() int64 {
+	return int64(max(ReplicationBatch, 1)) * maxFrameItem
+}
+
+// readFrame read frame and check its size and checksum
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if int64(size) > maxFrame() {
+		return 0, nil, ErrCorrupted
+	}
+	b := make([]byte, int64(size)+4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	payload := b[:len(b)-4]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(b[len(b)-4:]) {
+		return 0, nil, ErrCorrupted
+	}
+	return header[0], payload, nil
+}
+
+// appendChange append encoded change to payload of frame
+func appendChange(b []byte, c Change) []byte {
+	b = binary.BigEndian.AppendUint64(b, c.Seq)
+	b = append(b, byte(c.Op))
+	b = binary.AppendUvarint(b, uint64(len(c.Key)))
+	b = append(b, c.Key...)
+	switch c.Op {
+	case EVENT_SET:
+		if c.Value == nil {
+			b = append(b, 0)
+		} else {
+			b = append(b, 1)
+			b = binary.AppendUvarint(b, uint64(len(c.Value)))
+			b = append(b, c.Value...)
+		}
+		b = binary.AppendVarint(b, c.Expire)
+	case EVENT_DELETE_RANGE:
+		b = binary.AppendUvarint(b, uint64(len(c.End)))
+		b = append(b, c.End...)
+	}
+	return b
+}
+
+// decodeChanges return changes of frame payload
+func decodeChanges(b []byte) ([]Change, error) {
+	r := bytes.NewReader(b)
+	next := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, ErrCorrupted
+		}
+		v := make([]byte, n)
+		r.Read(v)
+		return v, nil
+	}
+	var changes []Change
+	for r.Len() > 0 {
+		var c Change
+		head := make([]byte, 9)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil, ErrCorrupted
+		}
+		c.Seq, c.Op = binary.BigEndian.Uint64(head), EventOp(head[8])
+		var err error
+		if c.Key, err = next(); err != nil {
+			return nil, err
+		}
+		switch c.Op {
+		case EVENT_SET:
+			hasValue, err := r.ReadByte()
+			if err != nil {
+				return nil, ErrCorrupted
+			}
+			if hasValue != 0 {
+				if c.Value, err = next(); err != nil {
+					return nil, err
+				}
+			}
+			if c.Expire, err = binary.ReadVarint(r); err != nil {
+				return nil, ErrCorrupted
+			}
+		case EVENT_DELETE:
+		case EVENT_DELETE_RANGE:
+			if c.End, err = next(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, ErrUnknownCommand
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// Follower keep replica of store served by Serve, see Follow
+type Follower struct {
+	db   *DB
+	addr string
+	// fp - file with position of replica, see savePos
+	fp *os.File
+	// seq - sequence number of last change of primary applied to replica
+	seq atomic.Uint64
+	// saved - time of last save of position
+	saved time.Time
+
+	mu     sync.Mutex
+	conn   net.Conn
+	err    error
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Follow open replica of store served by Serve at addr and keep it up to date in background
+// Replica may be read by Get, Keys, Snapshot and others, writes of it return ErrReadOnly
+// Empty replica is filled by snapshot of primary, then only changes are sent.
+// Position of replica is stored in file with REPLICA_FILE_EXT, so after restart follower
+// read changes after it. If they are compacted by primary, replica is replaced by snapshot
+// Connection is restored every FollowRetry, see Err. nil opts - default options
+func Follow(file, addr string, opts *Options) (*Follower, error) {
+	o := opts.options()
+	o.replica = true
+	db, err := openStore(file, o, true)
+	if err != nil {
+		return nil, err
+	}
+	f := &Follower{db: db, addr: addr, stop: make(chan struct{}), done: make(chan struct{})}
+	if f.fp, err = os.OpenFile(file+REPLICA_FILE_EXT, os.O_CREATE|os.O_RDWR, FILE_MODE); err != nil {
+		db.Close()
+		return nil, err
+	}
+	b := make([]byte, 12)
+	if n, _ := f.fp.ReadAt(b, 0); n == len(b) && crc32.Checksum(b[:8], crcTable) == binary.BigEndian.Uint32(b[8:]) {
+		f.seq.Store(binary.BigEndian.Uint64(b))
+	}
+	go f.run()
+	return f, nil
+}
+
+// Seq return sequence number of last change of primary applied to replica, see ChangesSince
+func (f *Follower) Seq() uint64 {
+	return f.seq.Load()
+}
+
+// Err return error of last connection to primary, nil if follower connected
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close disconnect follower, save its position and close replica
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrDbNotOpen
+	}
+	f.closed = true
+	close(f.stop)
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	<-f.done
+	err := f.savePos()
+	f.fp.Close()
+	if cerr := f.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// run connect to primary and apply its changes until Close
+func (f *Follower) run() {
+	defer close(f.done)
+	for {
+		err := f.follow()
+		f.mu.Lock()
+		f.err, f.conn = err, nil
+		f.mu.Unlock()
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(FollowRetry):
+		}
+	}
+}
+
+// follow connect to primary and apply frames until connection closed
+func (f *Follower) follow() error {
+	conn, err := net.DialTimeout("tcp", f.addr, FollowRetry)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrDbNotOpen
+	}
+	f.conn, f.err = conn, nil
+	f.mu.Unlock()
+
+	hello := append(append([]byte{}, replicationMagic...), REPLICATION_VERSION)
+	if _, err = conn.Write(binary.BigEndian.AppendUint64(hello, f.seq.Load())); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	// snapshot is applied by chunks, every chunk replace keys from end of last chunk
+	// to its last key, so keys of both old and new replica never disappear
+	var snapshot uint64
+	var from []byte
+	// pending - changes of frameChangesPart frames, applied with next frameChanges
+	var pending []Change
+	for {
+		t, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		switch t {
+		case frameSnapshot:
+			if len(payload) != 8 {
+				return ErrCorrupted
+			}
+			snapshot, from = binary.BigEndian.Uint64(payload), nil
+		case frameKeys:
+			keys, err := decodeChanges(payload)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				continue
+			}
+			end := append(append([]byte{}, keys[len(keys)-1].Key...), 0)
+			ops := []batchOp{{cmd: cmdDeleteRange, key: from, val: end}}
+			for _, c := range keys {
+				ops = append(ops, batchOp{cmd: cmdSet, key: c.Key, val: c.Value, expire: c.Expire})
+			}
+			if err = f.db.replicate(ops); err != nil {
+				return err
+			}
+			from = end
+		case frameSnapshotEnd:
+			if err = f.db.replicate([]batchOp{{cmd: cmdDeleteRange, key: from}}); err != nil {
+				return err
+			}
+			f.seq.Store(snapshot)
+			if err = f.savePos(); err != nil {
+				return err
+			}
+		case frameChangesPart:
+			changes, err := decodeChanges(payload)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, changes...)
+		case frameChanges:
+			changes, err := decodeChanges(payload)
+			if err != nil {
+				return err
+			}
+			changes, pending = append(pending, changes...), nil
+			if err = f.apply(changes); err != nil {
+				return err
+			}
+		default:
+			return ErrUnknownCommand
+		}
+	}
+}
+
+// apply write changes to replica by one batch, so batches of primary stay atomic
+// Value damaged in keys file of primary is not sent, then replica is replaced by snapshot
+// on next connection and ErrCorrupted returned
+func (f *Follower) apply(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	ops := make([]batchOp, 0, len(changes))
+	for _, c := range changes {
+		switch c.Op {
+		case EVENT_SET:
+			if c.Value == nil {
+				f.seq.Store(0)
+				return ErrCorrupted
+			}
+			ops = append(ops, batchOp{cmd: cmdSet, key: c.Key, val: c.Value, expire: c.Expire})
+		case EVENT_DELETE:
+			ops = append(ops, batchOp{cmd: cmdDelete, key: c.Key})
+		case EVENT_DELETE_RANGE:
+			ops = append(ops, batchOp{cmd: cmdDeleteRange, key: c.Key, val: c.End})
+		}
+	}
+	if err := f.db.replicate(ops); err != nil {
+		return err
+	}
+	f.seq.Store(changes[len(changes)-1].Seq)
+	if time.Since(f.saved) < FollowSaveInterval {
+		return nil
+	}
+	return f.savePos()
+}
+
+// savePos sync replica and then write its position: seq(8) CRC(4)
+// Changes are set, delete or range delete of keys, so if position is older then replica
+// changes after it are applied again with the same result
+func (f *Follower) savePos() error {
+	if err := f.db.sync(); err != nil {
+		return err
+	}
+	b := binary.BigEndian.AppendUint64(nil, f.seq.Load())
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(b, crcTable))
+	if _, err := f.fp.WriteAt(b, 0); err != nil {
+		return err
+	}
+	f.saved = time.Now()
+	return f.fp.Sync()
+}
@@ -0,0 +1,178 @@
+package gig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplication(t *testing.T) {
+	p, r := "tests/TestReplicationPrimary.db", "tests/TestReplicationReplica.db"
+	DeleteFile(p)
+	DeleteFile(r)
+	retry, batch := FollowRetry, ReplicationBatch
+	FollowRetry, ReplicationBatch = 10*time.Millisecond, 2
+	defer func() {
+		CloseAll()
+		FollowRetry, ReplicationBatch = retry, batch
+	}()
+
+	dump := func(file string) string {
+		it, err := NewIterator(file, nil)
+		ch(err, t)
+		defer it.Close()
+		var s []string
+		for key, val := range it.All() {
+			s = append(s, fmt.Sprintf("%s=%s", key, val))
+		}
+		ch(it.Err(), t)
+		return strings.Join(s, ",")
+	}
+	del := func(key string) {
+		_, err := Delete(p, []byte(key))
+		ch(err, t)
+	}
+	var f *Follower
+	wait := func() {
+		last, err := LastSeq(p)
+		ch(err, t)
+		for i := 0; f.Seq() != last && i < 500; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if f.Seq() != last {
+			t.Fatal("replica not synced", f.Seq(), last, f.Err())
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		ch(Set(p, []byte(fmt.Sprint("k", i)), []byte(fmt.Sprint("v", i))), t)
+	}
+	ch(Set(p, []byte("empty"), []byte{}), t)
+	ch(SetWithTTL(p, []byte("ttl"), []byte("t"), time.Hour), t)
+	_, err := DeleteRange(p, []byte("k1"), []byte("k3"))
+	ch(err, t)
+	// replica has keys, which are not in primary
+	ch(Set(r, []byte("stale"), []byte("s")), t)
+	ch(Close(r), t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ch(err, t)
+	defer l.Close()
+	go Serve(p, l)
+
+	// full snapshot
+	f, err = Follow(r, l.Addr().String(), nil)
+	ch(err, t)
+	wait()
+	if a, b := dump(p), dump(r); a != b {
+		t.Errorf("replica %q not match %q", b, a)
+	}
+	if ttl, err := TTL(r, []byte("ttl")); err != nil || ttl <= 0 {
+		t.Error("expiration not replicated", ttl, err)
+	}
+	if err = Set(r, []byte("x"), []byte("x")); err != ErrReadOnly {
+		t.Error("replica written", err)
+	}
+
+	// incremental changes
+	ch(Set(p, []byte("k0"), []byte("new")), t)
+	b := &WriteBatch{}
+	b.Put([]byte("b1"), []byte("1"))
+	b.Delete([]byte("k4"))
+	ch(Write(p, b), t)
+	_, err = DeletePrefix(p, []byte("k3"))
+	ch(err, t)
+	wait()
+	if a, b := dump(p), dump(r); a != b {
+		t.Errorf("replica %q not match %q", b, a)
+	}
+
+	// restart of follower read changes after its position
+	ch(f.Close(), t)
+	if pos, err := os.ReadFile(r + REPLICA_FILE_EXT); err != nil || binary.BigEndian.Uint64(pos) != f.Seq() {
+		t.Error("position not saved", pos, err)
+	}
+	ch(Set(p, []byte("k5"), []byte("v5")), t)
+	del("b1")
+	f, err = Follow(r, l.Addr().String(), nil)
+	ch(err, t)
+	wait()
+	if a, b := dump(p), dump(r); a != b {
+		t.Errorf("replica %q not match %q", b, a)
+	}
+
+	// changes after position compacted, replica replaced by snapshot
+	ch(f.Close(), t)
+	ch(Set(p, []byte("k6"), []byte("v6")), t)
+	del("k0")
+	_, err = Compact(p)
+	ch(err, t)
+	f, err = Follow(r, l.Addr().String(), nil)
+	ch(err, t)
+	wait()
+	if a, b := dump(p), dump(r); a != b {
+		t.Errorf("replica %q not match %q", b, a)
+	}
+
+	// frames split by size, batch bigger then frame applied at once
+	big := bytes.Repeat([]byte("v"), frameBudget()*2/3)
+	b = &WriteBatch{}
+	for i := 0; i < 3; i++ {
+		b.Put([]byte(fmt.Sprint("big", i)), big)
+	}
+	ch(Write(p, b), t)
+	// value bigger then budget has frame of its own
+	ch(Set(p, []byte("huge"), bytes.Repeat([]byte("h"), frameBudget()+1)), t)
+	wait()
+	if a, b := dump(p), dump(r); a != b {
+		t.Error("big values not replicated")
+	}
+	ch(f.Close(), t)
+}
+
+func TestFollowDamaged(t *testing.T) {
+	r := "tests/TestFollowDamaged.db"
+	DeleteFile(r)
+	defer CloseAll()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ch(err, t)
+	l.Close()
+	f, err := Follow(r, l.Addr().String(), nil)
+	ch(err, t)
+	defer f.Close()
+	ch(f.apply([]Change{{Seq: 10, Op: EVENT_SET, Key: []byte("a"), Value: []byte{}}}), t)
+	// damaged value of primary is not skipped, replica is replaced by snapshot
+	if err = f.apply([]Change{{Seq: 20, Op: EVENT_SET, Key: []byte("b")}}); err != ErrCorrupted || f.Seq() != 0 {
+		t.Error("damaged value applied", f.Seq(), err)
+	}
+	if b, err := Get(r, []byte("a")); err != nil || len(b) != 0 {
+		t.Error("empty value not applied", b, err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	ch(writeFrame(&buf, frameKeys, []byte("payload")), t)
+	b := buf.Bytes()
+	if typ, payload, err := readFrame(bytes.NewReader(b)); err != nil || typ != frameKeys || string(payload) != "payload" {
+		t.Error("wrong frame", typ, string(payload), err)
+	}
+	damaged := append([]byte{}, b...)
+	damaged[6] = 'X'
+	if _, _, err := readFrame(bytes.NewReader(damaged)); err != ErrCorrupted {
+		t.Error("damaged frame read", err)
+	}
+	if err := writeFrame(&buf, frameKeys, make([]byte, maxFrame()+1)); err != ErrFrameTooBig {
+		t.Error("frame bigger then follower accept written", err)
+	}
+	// size is checked before payload is read
+	huge := []byte{frameKeys, 0xff, 0xff, 0xff, 0xff}
+	if _, _, err := readFrame(bytes.NewReader(huge)); err != ErrCorrupted {
+		t.Error("huge frame read", err)
+	}
+}
@@ -4,19 +4,53 @@ package gig
 
 import (
 	"bytes"
+	"container/heap"
 	"context"
+	"crypto/cipher"
 	"encoding/binary"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
-	"sort"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Cmd - struct with commands stored in keys
 type Cmd struct {
-	Seek    uint32
+	Seek    uint64
 	Size    uint32
-	KeySeek uint32
+	CRC     uint32
+	KeySeek uint64
+	// Expire - unix time in milliseconds when key expire, 0 - never
+	Expire int64
+	// Codec of stored value, Size and CRC are of stored bytes
+	Codec Codec
+	// Sealed is true if value sealed by key of store
+	Sealed bool
+}
+
+// record return set record of key
+func (cmd *Cmd) record(version uint8, key []byte) *keyRecord {
+	rec := &keyRecord{version: version, cmd: cmdSet | uint8(cmd.Codec)<<codecShift,
+		seek: cmd.Seek, size: cmd.Size, crc: cmd.CRC, key: key}
+	if cmd.Sealed {
+		rec.cmd |= flagSealed
+	}
+	if cmd.Expire != 0 {
+		rec.cmd |= flagExpire
+		rec.expire = cmd.Expire
+	}
+	return rec
+}
+
+// keySize return size of set record of key
+func (cmd *Cmd) keySize(version uint8, key []byte) int64 {
+	if cmd.Expire != 0 {
+		return keyRecordSize(version, cmdSet|flagExpire, key)
+	}
+	return keyRecordSize(version, cmdSet, key)
 }
 
 // writeAtPos store bytes to file
@@ -40,337 +74,775 @@ func writeAtPos(f *os.File, b []byte, pos int64, withSync bool) (seek int64, n i
 	return seek, n, err
 }
 
-// writeKey create buffer and store key with val address and size
-func writeKey(fk *os.File, t uint8, seek, size uint32, key []byte, sync bool, keySeek int64) (newSeek int64, err error) {
+// writeKey create buffer and append key with val address, size and checksum
+// record written in format version of rec
+func writeKey(fk *os.File, rec *keyRecord, sync bool) (newSeek int64, err error) {
 	//get buf from pool
 	buf := bufPool.Get().(*bytes.Buffer)
 	defer bufPool.Put(buf)
 	buf.Reset()
-	buf.Grow(16 + len(key))
+	buf.Grow(int(keyRecordSize(rec.version, rec.cmd, rec.key)))
 
 	//encode
-	binary.Write(buf, binary.BigEndian, uint8(0))                  //1byte version
-	binary.Write(buf, binary.BigEndian, t)                         //1byte command code(0-set,1-delete)
-	binary.Write(buf, binary.BigEndian, seek)                      //4byte seek
-	binary.Write(buf, binary.BigEndian, size)                      //4byte size
-	binary.Write(buf, binary.BigEndian, uint32(time.Now().Unix())) //4byte timestamp
-	binary.Write(buf, binary.BigEndian, uint16(len(key)))          //2byte key size
-	buf.Write(key)                                                 //key
-
-	if sync {
-		if keySeek < 0 {
-			newSeek, _, err = writeAtPos(fk, buf.Bytes(), int64(-1), true) //fk.Write(buf.Bytes())
-		} else {
-			newSeek, _, err = writeAtPos(fk, buf.Bytes(), int64(keySeek), true) //fk.WriteAt(buf.Bytes(), int64(keySeek))
+	if err = encodeKey(buf, rec); err != nil {
+		return -1, err
+	}
+	// records only appended, so torn write may damage only the last one
+	newSeek, _, err = writeAtPos(fk, buf.Bytes(), int64(-1), sync) //fk.Write(buf.Bytes())
+	return newSeek, err
+}
+
+// readVal read value from fv, check it, unseal and decompress
+func readVal(fv *os.File, version uint8, aead cipher.AEAD, key []byte, cmd *Cmd) ([]byte, error) {
+	b, err := readStored(fv, version, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return openVal(version, aead, key, cmd, b)
+}
+
+// openVal unseal and decompress stored bytes of value of key
+func openVal(version uint8, aead cipher.AEAD, key []byte, cmd *Cmd, b []byte) (val []byte, err error) {
+	if cmd.Sealed {
+		if b, err = unseal(aead, version, key, b); err != nil {
+			return nil, err
 		}
+	}
+	return decompress(cmd.Codec, b)
+}
 
-	} else {
-		newSeek, _, err = writeAtPos(fk, buf.Bytes(), int64(-1), false) //fk.WriteNoSync(buf.Bytes())
+// readStored read stored bytes of value from fv and check them if format has checksums
+func readStored(fv *os.File, version uint8, cmd *Cmd) ([]byte, error) {
+	b := make([]byte, cmd.Size)
+	if _, err := fv.ReadAt(b, int64(cmd.Seek)); err != nil {
+		return nil, err
 	}
+	if version >= 2 && crc32.Checksum(b, crcTable) != cmd.CRC {
+		return nil, ErrCorrupted
+	}
+	return b, nil
+}
 
-	return newSeek, err
+// store hold opened files and in-memory index
+// Store changed only by run goroutine, so writers are serialized,
+// index and files are changed under mu, readers use them under shared lock
+type store struct {
+	mu   sync.RWMutex
+	file string
+	fk   *os.File
+	fv   *os.File
+	// fl - locked lock file, see lockStore
+	fl *os.File
+	// fr - lock file of readers of other processes, see shared
+	fr *os.File
+	// readOnly is true if store opened by OpenReadOnly, files are never written
+	readOnly bool
+	// version of files format
+	version uint8
+	// valDict map with key and address of values
+	valDict map[string]*Cmd
+	// index store ordered keys, see node
+	index *node
+	// loading is true while index not updated by setCmd and delCmd
+	loading bool
+	// countersDict store counters
+	countersDict map[string]uint64
+	// sizes of files and sizes of live records in them
+	keySize, valSize int64
+	liveKey, liveVal int64
+	// compacting not nil while compaction in progress
+	compacting *compaction
+	// recovery not nil if damaged records was dropped on open
+	recovery *Recovery
+	// expires ordered by expiration time, see sweep
+	expires expireHeap
+	// views - count of open views for every values file, see Snapshot
+	views map[*os.File]int
+	// closed is true after store goroutine exit
+	closed bool
+	// generation of keys file, see checkpoint
+	generation uint64
+	// compactedSeq - sequence number of end of keys file swapped by last compaction,
+	// reader caught up with it continue from compactedEnd of new keys file, see changesSince
+	compactedSeq uint64
+	compactedEnd int64
+	// checkpointed - offset in keys file covered by last checkpoint
+	checkpointed int64
+	// checkpointing not nil while checkpoint written in background
+	checkpointing chan checkpointResult
+	// opts of store, see Options
+	opts Options
+	// unsynced - count of writes after last sync
+	unsynced int
+	// valDirty is true if values file written after last sync
+	valDirty bool
+	// writers - count of Set calls in progress, see setGroup
+	writers atomic.Int32
+	// aead seal values if store opened with key, see Options
+	aead cipher.AEAD
+	// closeErr - error of sync and close of files on Close
+	closeErr error
+	// watchers of changes guarded by watchMu, watched is count of them, see Watch
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{}
+	watched  atomic.Int32
+	// changes made by current request, they are sent to watchers after it
+	changes []Event
 }
 
-func writeKeyVal(fk *os.File, fv *os.File, readKey string, writeVal []byte, exists bool, oldCmd *Cmd) (cmd *Cmd, err error) {
+// Recovery describe damaged records dropped from the end of keys file on open
+type Recovery struct {
+	// Offset - keys file was truncated at this offset
+	Offset int64
+	// Dropped - count of dropped bytes
+	Dropped int64
+	// Err - why first dropped record was rejected
+	Err error
+}
 
-	var seek, newSeek int64
-	cmd = &Cmd{Size: uint32(len(writeVal))}
-	if exists {
-		// key exists
-		cmd.Seek = oldCmd.Seek
-		cmd.KeySeek = oldCmd.KeySeek
-		if oldCmd.Size >= uint32(len(writeVal)) {
-			//write at old seek new value
-			_, _, err = writeAtPos(fv, writeVal, int64(oldCmd.Seek), true)
-		} else {
-			//write at new seek (at the end of file)
-			seek, _, err = writeAtPos(fv, writeVal, int64(-1), true)
-			cmd.Seek = uint32(seek)
-		}
-		if err == nil {
-			// if no error - store key at KeySeek
-			newSeek, err = writeKey(fk, 0, cmd.Seek, cmd.Size, []byte(readKey), true, int64(cmd.KeySeek))
-			cmd.KeySeek = uint32(newSeek)
+// setCmd store command for key and update live sizes, size is length of value for watchers
+// It must be called under lock if store goroutine is started
+func (s *store) setCmd(key []byte, cmd *Cmd, size int) {
+	strkey := string(key)
+	if old, exists := s.valDict[strkey]; exists {
+		s.liveKey -= old.keySize(s.version, key)
+		s.liveVal -= int64(old.Size)
+	}
+	if !s.loading {
+		s.index = s.index.put(key, cmd)
+	}
+	s.valDict[strkey] = cmd
+	s.liveKey += cmd.keySize(s.version, key)
+	s.liveVal += int64(cmd.Size)
+	if cmd.Expire != 0 {
+		heap.Push(&s.expires, expireItem{expire: cmd.Expire, key: strkey})
+	}
+	s.markDirty(strkey)
+	s.changed(EVENT_SET, key, size)
+}
+
+// delCmd remove key from index and update live sizes
+// It must be called under lock if store goroutine is started
+func (s *store) delCmd(key []byte) {
+	strkey := string(key)
+	if old, exists := s.valDict[strkey]; exists {
+		s.liveKey -= old.keySize(s.version, key)
+		s.liveVal -= int64(old.Size)
+		delete(s.valDict, strkey)
+		if !s.loading {
+			s.index = s.index.remove(key)
 		}
-	} else {
-		// new key
-		// write value at the end of file
-		seek, _, err = writeAtPos(fv, writeVal, int64(-1), true)
-		cmd.Seek = uint32(seek)
-		if err == nil {
-			newSeek, err = writeKey(fk, 0, cmd.Seek, cmd.Size, []byte(readKey), true, -1)
-			cmd.KeySeek = uint32(newSeek)
+		s.changed(EVENT_DELETE, key, 0)
+	}
+	s.markDirty(strkey)
+}
+
+// markDirty remember key changed while compaction in progress
+func (s *store) markDirty(key string) {
+	if s.compacting != nil {
+		s.compacting.dirty[key] = struct{}{}
+	}
+}
+
+// grow update file sizes after write
+func (s *store) grow(keySeek, valSeek int64, keyLen, valLen int) {
+	if end := keySeek + int64(keyLen); end > s.keySize {
+		s.keySize = end
+	}
+	if end := valSeek + int64(valLen); end > s.valSize {
+		s.valSize = end
+	}
+}
+
+// deadBytes return count of bytes not used by live keys and values
+func (s *store) deadBytes() int64 {
+	dead := s.keySize - s.liveKey + s.valSize - s.liveVal
+	if s.version > 0 {
+		dead -= 2 * HEADER_SIZE
+	}
+	return dead
+}
+
+// replay read key records and build index
+// Records covered by checkpoint are not read, see loadCheckpoint
+// Damaged tail of keys file (after crash while writing) will be truncated
+func (s *store) replay() error {
+	s.reset()
+	defer s.indexAll()
+	base := s.loadCheckpoint()
+	if _, err := s.fk.Seek(base, 0); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(s.fk) //fk.ReadFile()
+	if err != nil {
+		return err
+	}
+	end, err := s.load(b, int(base), nil)
+	// tail of store opened read-only may be written by other process just now
+	if err != nil && !s.readOnly {
+		s.recovery = &Recovery{Offset: int64(end), Dropped: base + int64(len(b)) - int64(end), Err: err}
+		if err = s.fk.Truncate(int64(end)); err != nil {
+			return err
 		}
 	}
-	return cmd, err
+	s.keySize = int64(end)
+	if info, err := s.fv.Stat(); err == nil {
+		s.valSize = info.Size()
+	}
+	/*
+		for k, v := range valDict {
+			fmt.Printf("%+v:%+v\n", k, v)
+		}
+	*/
+	return nil
 }
 
-// run read keys from *.idx store and run listeners
-func run(parentCtx context.Context, fk *os.File, fv *os.File,
-	readRequests <-chan readRequest, writeRequests <-chan writeRequest,
-	deleteRequests <-chan deleteRequest, keysRequests <-chan keysRequest,
-	setsRequests <-chan setsRequest, getsRequests <-chan getsRequest,
-	hasRequests <-chan hasRequest,
-	counterGetRequests <-chan counterGetRequest, counterSetRequests <-chan counterSetRequest) error {
-	ctx, cancel := context.WithCancel(parentCtx)
-	defer cancel()
-	// valDict map with key and address of values
-	valDict := make(map[string]*Cmd)
-	// keysDict store ordered slice of keys
-	var keysDict = make([][]byte, 0)
-	// countersDict store counters
-	countersDict := make(map[string]uint64)
+// reset clear index before load
+// Index built at once by indexAll after all records loaded
+func (s *store) reset() {
+	s.valDict = make(map[string]*Cmd)
+	s.loading = true
+	s.countersDict = make(map[string]uint64)
+	s.expires = nil
+}
 
-	//delete key from slice keysDict
-	deleteFromKeys := func(b []byte) {
-		found := sort.Search(len(keysDict), func(i int) bool {
-			return bytes.Compare(keysDict[i], b) >= 0
-		})
-		if found < len(keysDict) {
-			//fmt.Printf("found:%d key:%+v keys:%+v\n", found, b, keysDict)
-			if bytes.Equal(keysDict[found], b) {
-				keysDict = append(keysDict[:found], keysDict[found+1:]...)
+// load apply records from content of keys file to index
+// b is content of keys file from offset base
+// If visit not nil, it called for every record before record applied
+// Records of batch applied on commit record, batch without commit is discarded
+// Return offset after last good record and error of first bad record,
+// if batch is not committed, offset of its first record
+func (s *store) load(b []byte, base int, visit func(rec keyRecord, offset int)) (int, error) {
+	var pending []indexOp
+	batchStart := -1
+	readSeek := base
+	if s.version > 0 && readSeek < HEADER_SIZE {
+		readSeek = HEADER_SIZE
+	}
+	for readSeek-base < len(b) {
+		rec, n, err := decodeKey(b[readSeek-base:])
+		if err == nil && rec.version != s.version {
+			// zeros of torn tail are decoded as record of version 0
+			err = errVersion
+		}
+		if err != nil {
+			if batchStart >= 0 {
+				return batchStart, err
 			}
+			return readSeek, err
+		}
+		if visit != nil {
+			visit(rec, readSeek)
+		}
+		// copy key, so whole file will not stay in memory
+		op := indexOp{
+			op:  rec.cmd & cmdMask,
+			key: append([]byte{}, rec.key...),
+			cmd: &Cmd{
+				Seek:    rec.seek,
+				Size:    rec.size,
+				CRC:     rec.crc,
+				KeySeek: uint64(readSeek),
+				Expire:  rec.expire,
+				Codec:   rec.codec(),
+				Sealed:  rec.cmd&flagSealed != 0,
+			},
 		}
+		switch {
+		case op.op == cmdCommit:
+			if batchStart >= 0 && int(rec.size) == len(pending) {
+				for _, p := range pending {
+					s.apply(p)
+				}
+			}
+			pending, batchStart = nil, -1
+		case rec.cmd&flagBatch != 0:
+			if batchStart < 0 {
+				batchStart = readSeek
+			}
+			pending = append(pending, op)
+		default:
+			// batch abandoned after failed write
+			pending, batchStart = nil, -1
+			s.apply(op)
+		}
+		readSeek += n
+	}
+	if batchStart >= 0 {
+		return batchStart, errUncommitted
 	}
+	return readSeek, nil
+}
 
-	//appendAsc insert key in slice in ascending order
-	appendAsc := func(b []byte) {
-		keysLen := len(keysDict)
-		found := sort.Search(keysLen, func(i int) bool {
-			return bytes.Compare(keysDict[i], b) >= 0
-		})
-		if found == 0 {
-			//prepend
-			keysDict = append([][]byte{b}, keysDict...)
+// indexOp - command of key record to apply in index
+type indexOp struct {
+	op  uint8
+	key []byte
+	cmd *Cmd
+}
 
-		} else {
-			if found >= keysLen {
-				//not found - postpend ;)
-				keysDict = append(keysDict, b)
-			} else {
-				//found
-				//https://blog.golang.org/go-slices-usage-and-internals
-				keysDict = append(keysDict, nil)           //grow origin slice capacity if needed
-				copy(keysDict[found+1:], keysDict[found:]) //ha-ha, lol, 20x faster
-				keysDict[found] = b
+// apply store set or delete command in index
+func (s *store) apply(op indexOp) {
+	switch op.op {
+	case cmdSet:
+		s.setCmd(op.key, op.cmd, s.valLen(op.key, op.cmd))
+	case cmdDelete:
+		s.delCmd(op.key)
+	case cmdDeleteRange:
+		if int(op.cmd.Size) <= len(op.key) {
+			s.delRange(op.key[:op.cmd.Size], op.key[op.cmd.Size:])
+		}
+	}
+}
+
+// set write value and key, then store command in index
+// expire is unix time in milliseconds, 0 - key never expire
+// Value overwritten in place if it fits, readers must not see new value with old checksum,
+// so it written and stored in index under lock, then synced without lock
+// Since version 2 value is appended and then key record with its checksum is written,
+// so value is never overwritten, after crash between writes old value is still valid
+func (s *store) set(key string, val []byte, expire int64) error {
+	if expire != 0 && s.version == 0 {
+		return ErrNeedUpgrade
+	}
+	size := len(val)
+	val, cmd := s.encodeVal([]byte(key), val)
+	cmd.Expire = expire
+	oldCmd, exists := s.valDict[key]
+	// value of view must not be overwritten
+	inPlace := exists && s.version < 2 && oldCmd.Size >= cmd.Size && !s.pinned()
+	if inPlace {
+		cmd.Seek = oldCmd.Seek
+	} else {
+		// new key or bigger value
+		// write value at the end of file
+		// value synced first, so key record never point to lost value
+		seek, _, err := writeAtPos(s.fv, val, int64(-1), s.syncAlways())
+		if err != nil {
+			return err
+		}
+		cmd.Seek = uint64(seek)
+		s.valDirty = s.valDirty || !s.syncAlways()
+	}
+	keySeek, err := writeKey(s.fk, cmd.record(s.version, []byte(key)), false)
+	if err != nil {
+		return err
+	}
+	cmd.KeySeek = uint64(keySeek)
+
+	s.mu.Lock()
+	if inPlace {
+		//write at old seek new value
+		_, _, err = writeAtPos(s.fv, val, int64(cmd.Seek), false)
+		s.valDirty = true
+	}
+	if err == nil {
+		s.setCmd([]byte(key), cmd, size)
+	}
+	s.mu.Unlock()
+	s.grow(keySeek, int64(cmd.Seek), int(cmd.keySize(s.version, []byte(key))), len(val))
+	if err != nil {
+		return err
+	}
+	return s.written()
+}
+
+// setGroup write values and keys of many requests with one write and sync of every file
+// Values always appended, so they synced before key records like in set
+// Return error for every request
+func (s *store) setGroup(group []writeRequest) []error {
+	errs := make([]error, len(group))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
 			}
 		}
+		return errs
 	}
-	//read keys
-	//get buf from pool
 	buf := bufPool.Get().(*bytes.Buffer)
 	defer bufPool.Put(buf)
 	buf.Reset()
 
-	b, _ := ioutil.ReadAll(fk) //fk.ReadFile()
-	buf.Write(b)
-	var readSeek uint32
-	for buf.Len() > 0 {
-		_ = uint8(buf.Next(1)[0]) //format version
-		t := uint8(buf.Next(1)[0])
-		seek := binary.BigEndian.Uint32(buf.Next(4))
-		size := binary.BigEndian.Uint32(buf.Next(4))
-		_ = buf.Next(4) //time
-		sizeKey := int(binary.BigEndian.Uint16(buf.Next(2)))
-		key := buf.Next(sizeKey)
-		strkey := string(key)
-		cmd := &Cmd{
-			Seek:    seek,
-			Size:    size,
-			KeySeek: readSeek,
+	cmds := make([]*Cmd, len(group))
+	offsets := make([]int64, len(group))
+	var valSeek int64 = -1
+	var valLen int
+	for i, wr := range group {
+		if wr.expire != 0 && s.version == 0 {
+			errs[i] = ErrNeedUpgrade
+			continue
+		}
+		val, cmd := s.encodeVal([]byte(wr.readKey), wr.writeVal)
+		cmd.Expire = wr.expire
+		seek, _, err := writeAtPos(s.fv, val, int64(-1), false)
+		if err != nil {
+			return fail(err)
+		}
+		cmd.Seek = uint64(seek)
+		valSeek, valLen = seek, len(val)
+		offsets[i] = int64(buf.Len())
+		if errs[i] = encodeKey(buf, cmd.record(s.version, []byte(wr.readKey))); errs[i] != nil {
+			continue
+		}
+		cmds[i] = cmd
+	}
+	if buf.Len() == 0 {
+		return errs
+	}
+	if s.syncAlways() {
+		if err := s.fv.Sync(); err != nil {
+			return fail(err)
+		}
+	} else {
+		s.valDirty = true
+	}
+	start, _, err := writeAtPos(s.fk, buf.Bytes(), int64(-1), false)
+	if err != nil {
+		if start > 0 {
+			s.fk.Truncate(start)
+		}
+		return fail(err)
+	}
+
+	s.mu.Lock()
+	for i, wr := range group {
+		if cmds[i] != nil {
+			cmds[i].KeySeek = uint64(start + offsets[i])
+			s.setCmd([]byte(wr.readKey), cmds[i], len(wr.writeVal))
+		}
+	}
+	s.mu.Unlock()
+	s.grow(start, valSeek, buf.Len(), valLen)
+	return fail(s.written())
+}
+
+// get read value of key, many readers run in parallel under shared lock
+func (s *store) get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrDbNotOpen
+	}
+	cmd, exists := s.valDict[key]
+	if !exists || cmd.expired(now()) {
+		// if no key return eror
+		return nil, ErrKeyNotFound
+	}
+	return readVal(s.fv, s.version, s.aead, []byte(key), cmd)
+}
+
+// gets return pairs of existing keys and values
+func (s *store) gets(keys [][]byte) [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([][]byte, 0)
+	if s.closed {
+		return result
+	}
+	t := now()
+	for _, key := range keys {
+		if cmd, exists := s.valDict[string(key)]; exists && !cmd.expired(t) {
+			b, err := readVal(s.fv, s.version, s.aead, key, cmd)
+			if err != nil {
+				// damaged values skipped like not found
+				continue
+			}
+			result = append(result, key, b)
 		}
-		readSeek += uint32(16 + sizeKey)
-		switch t {
-		case 0:
-			if _, exists := valDict[strkey]; !exists {
-				//write new key at keys store
-				//keysDict = append(keysDict, key)
-				appendAsc(key)
+	}
+	return result
+}
+
+// has return true if key exists and not expired
+func (s *store) has(key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false, ErrDbNotOpen
+	}
+	cmd, exists := s.valDict[key]
+	return exists && !cmd.expired(now()), nil
+}
+
+// keys return keys like Keys, ok is false if expired keys must be swept first
+func (s *store) keys(from []byte, limit, offset uint32, asc bool) (keys [][]byte, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return make([][]byte, 0), true
+	}
+	if s.hasExpired() {
+		return nil, false
+	}
+	return findKeys(s.index, from, limit, offset, asc), true
+}
+
+// count return count of keys, ok is false if expired keys must be swept first
+func (s *store) count() (cnt uint64, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed || s.hasExpired() {
+		return 0, s.closed
+	}
+	return uint64(s.index.len()), true
+}
+
+// writeDelete append delete record of key to keys file
+func (s *store) writeDelete(key []byte, sync bool) error {
+	rec := &keyRecord{version: s.version, cmd: cmdDelete, key: key}
+	seek, err := writeKey(s.fk, rec, sync)
+	if err == nil {
+		s.grow(seek, 0, int(keyRecordSize(s.version, cmdDelete, key)), 0)
+	}
+	return err
+}
+
+// autoCompact start compaction if too many dead bytes
+func (s *store) autoCompact() {
+	if AutoCompactRatio <= 0 || s.compacting != nil {
+		return
+	}
+	total := s.keySize + s.valSize
+	if total < AutoCompactMinSize {
+		return
+	}
+	if float64(s.deadBytes()) >= AutoCompactRatio*float64(total) {
+		s.startCompact(compactRequest{})
+	}
+}
+
+// findKeys return keys from ordered index like Keys
+// Keys found by position, so offset cost is logarithmic
+func findKeys(index *node, fromKey []byte, limit, offset uint32, asc bool) [][]byte {
+	var result [][]byte
+	result = make([][]byte, 0)
+	lenKeys := index.len()
+	var start, end, found int
+	var byPrefix bool
+	found = -1
+	if fromKey != nil {
+		if bytes.Equal(fromKey[len(fromKey)-1:], []byte("*")) {
+			byPrefix = true
+			fromKey = fromKey[:len(fromKey)-1]
+		}
+		found = index.search(func(key []byte) bool {
+			return bytes.Compare(key, fromKey) < 0
+		})
+		if !asc && byPrefix {
+			// last key with prefix, keys with prefix follow keys less then prefix
+			found = index.search(func(key []byte) bool {
+				return bytes.Compare(key, fromKey) < 0 || bytes.HasPrefix(key, fromKey)
+			}) - 1
+			if found < 0 || !bytes.HasPrefix(index.at(found).key, fromKey) {
+				found = lenKeys
+			}
+		}
+		if found == lenKeys {
+			//not found
+			found = -1
+		} else {
+			//found
+			if !byPrefix && !bytes.Equal(index.at(found).key, fromKey) {
+				found = -1 //not eq
 			}
-			valDict[strkey] = cmd
-		case 1:
-			delete(valDict, strkey)
-			deleteFromKeys(key)
 		}
+		// if not found - found will == len and return empty array
 	}
-	/*
-		for k, v := range valDict {
-			fmt.Printf("%+v:%+v\n", k, v)
+	// ascending order
+	if asc {
+		start = 0
+		if fromKey != nil {
+			if found == -1 {
+				start = lenKeys
+			} else {
+				start = found + 1
+				if byPrefix {
+					//include
+					start = found
+				}
+			}
 		}
-	*/
+		if offset > 0 {
+			start += int(offset)
+		}
+		end = lenKeys
+		if limit > 0 {
+			end = start + int(limit)
+			if end > lenKeys {
+				end = lenKeys
+			}
+		}
+		i := start
+		index.ascend(start, func(n *node) bool {
+			if i >= end || byPrefix && !bytes.HasPrefix(n.key, fromKey) {
+				return false
+			}
+			result = append(result, n.key)
+			i++
+			return true
+		})
+	} else {
+		//descending
+		start = lenKeys - 1
+		if fromKey != nil {
+			if found == -1 {
+				start = -1
+			} else {
+				start = found - 1
+				if byPrefix {
+					//include
+					start = found
+				}
+			}
+		}
+
+		if offset > 0 {
+			start -= int(offset)
+		}
+		end = 0
+		if limit > 0 {
+			end = start - int(limit) + 1
+			if end < 0 {
+				end = 0
+			}
+		}
+		i := start
+		index.descend(start, func(n *node) bool {
+			if i < end || byPrefix && !bytes.HasPrefix(n.key, fromKey) {
+				return false
+			}
+			result = append(result, n.key)
+			i--
+			return true
+		})
+	}
+	return result
+}
+
+// run read keys from *.idx store and run listeners
+func run(parentCtx context.Context, s *store,
+	writeRequests <-chan writeRequest,
+	deleteRequests <-chan deleteRequest, rangeRequests <-chan deleteRangeRequest, keysRequests <-chan keysRequest,
+	setsRequests <-chan setsRequest,
+	counterGetRequests <-chan counterGetRequest, counterSetRequests <-chan counterSetRequest,
+	compactRequests <-chan compactRequest, expireRequests <-chan expireRequest,
+	batchRequests <-chan batchRequest, snapshotRequests <-chan snapshotRequest,
+	checkpointRequests <-chan chan error, syncRequests <-chan chan error, refreshRequests <-chan chan error,
+	changesRequests <-chan changesRequest) error {
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+	sweeper := time.NewTicker(SweepInterval)
+	defer sweeper.Stop()
+	// syncer is nil if writes not synced by time
+	var syncer <-chan time.Time
+	if s.opts.Sync == SYNC_INTERVAL && s.opts.SyncInterval > 0 {
+		ticker := time.NewTicker(s.opts.SyncInterval)
+		defer ticker.Stop()
+		syncer = ticker.C
+	}
+	// refresher is nil if store is not refreshed by time
+	var refresher <-chan time.Time
+	if s.readOnly && s.opts.RefreshInterval > 0 {
+		ticker := time.NewTicker(s.opts.RefreshInterval)
+		defer ticker.Stop()
+		refresher = ticker.C
+	}
 
 	for {
+		// changes not sent by written, like refreshed records
+		s.notify()
 		select {
 		case <-ctx.Done():
 			// start on Close()
-			fk.Close()
-			fv.Close()
+			if s.compacting != nil {
+				s.abortCompact()
+			}
+			if s.checkpointing != nil {
+				s.finishCheckpoint(<-s.checkpointing)
+			}
+			if !s.readOnly && s.version > 0 && CheckpointBytes > 0 && s.keySize-s.checkpointed >= CheckpointBytes {
+				s.checkpoint()
+			}
+			// flush writes not synced yet
+			err := s.sync()
+			s.mu.Lock()
+			s.closed = true
+			s.closeViews()
+			s.closeWatchers()
+			// other process may open store after files closed
+			if errClose := s.closeFiles(); err == nil {
+				err = errClose
+			}
+			s.closeErr = err
+			s.mu.Unlock()
 			//fmt.Println("done")
-			return nil
+			return err
 		case dr := <-deleteRequests:
 			//fmt.Println("del")
-			//for _, v := range valDict {
+			//for _, v := range s.valDict {
 			//fmt.Printf("%+v\n", v)
 			//}
-			delete(valDict, dr.deleteKey)
-			deleteFromKeys([]byte(dr.deleteKey))
-			// delete command append to the end of keys file
-			writeKey(fk, 1, 0, 0, []byte(dr.deleteKey), true, -1)
-			close(dr.responseChan)
-		case wr := <-writeRequests:
-			oldCmd, exists := valDict[wr.readKey]
-			cmd, err := writeKeyVal(fk, fv, wr.readKey, wr.writeVal, exists, oldCmd)
-			if !exists {
-				appendAsc([]byte(wr.readKey))
-			}
+			// delete command append to the end of keys file, key deleted from index after it written
+			err := s.writeDelete([]byte(dr.deleteKey), false)
 			if err == nil {
-				//fmt.Printf("wr:%s %+v\n", wr.readKey, cmd)
-				// store command if no error
-				valDict[wr.readKey] = cmd
+				s.mu.Lock()
+				s.delCmd([]byte(dr.deleteKey))
+				s.mu.Unlock()
+				err = s.written()
 			}
-			wr.responseChan <- writeResponse{err}
-		case rr := <-readRequests:
-			if val, exists := valDict[rr.readKey]; exists {
-				//fmt.Printf("rr:%s %+v\n", rr.readKey, val)
-				b := make([]byte, val.Size)
-				_, err := fv.ReadAt(b, int64(val.Seek))
-				rr.responseChan <- readResponse{b, err}
-			} else {
-				// if no key return eror
-				rr.responseChan <- readResponse{nil, ErrKeyNotFound}
+			dr.responseChan <- err
+			s.autoCompact()
+		case rr := <-rangeRequests:
+			deleted, err := s.deleteRange(rr.start, rr.end)
+			rr.responseChan <- deleteRangeResponse{deleted: deleted, err: err}
+			s.autoCompact()
+		case wr := <-writeRequests:
+			// requests already queued by other goroutines written together
+			group := []writeRequest{wr}
+			if s.writers.Load() > 1 {
+				// let other writers queue their requests
+				runtime.Gosched()
 			}
-
-		case kr := <-keysRequests:
-			var result [][]byte
-			result = make([][]byte, 0)
-			lenKeys := len(keysDict)
-			var start, end, found int
-			var byPrefix bool
-			found = -1
-			if kr.fromKey != nil {
-				if bytes.Equal(kr.fromKey[len(kr.fromKey)-1:], []byte("*")) {
-					byPrefix = true
-					_ = byPrefix
-					kr.fromKey = kr.fromKey[:len(kr.fromKey)-1]
-					//fmt.Println(string(kr.fromKey))
-				}
-				found = sort.Search(lenKeys, func(i int) bool {
-					//bynary search may return not eq result
-					return bytes.Compare(keysDict[i], kr.fromKey) >= 0
-				})
-				if !kr.asc && byPrefix {
-					//iterate if desc and by prefix
-					found = lenKeys
-					for j := lenKeys - 1; j >= 0; j-- {
-						if len(keysDict[j]) >= len(kr.fromKey) {
-							if bytes.Equal(keysDict[j][:len(kr.fromKey)], kr.fromKey) {
-								found = j
-								break
-								//fmt.Println("found:", found, string(keysDict[j][:len(kr.fromKey)]), string(keysDict[j]))
-							}
-						}
-					}
+			for more := true; more && len(group) < GroupWrites; {
+				select {
+				case wr := <-writeRequests:
+					group = append(group, wr)
+				default:
+					more = false
 				}
-				if found == lenKeys {
-					//not found
-					found = -1
-				} else {
-					//found
-					if !byPrefix && !bytes.Equal(keysDict[found], kr.fromKey) {
-						found = -1 //not eq
-					}
-				}
-				// if not found - found will == len and return empty array
-				//fmt.Println(string(kr.fromKey), found)
 			}
-			// ascending order
-			if kr.asc {
-				start = 0
-				if kr.fromKey != nil {
-					if found == -1 {
-						start = lenKeys
-					} else {
-						start = found + 1
-						if byPrefix {
-							//include
-							start = found
-						}
-					}
-				}
-				if kr.offset > 0 {
-					start += int(kr.offset)
-				}
-				end = lenKeys
-				if kr.limit > 0 {
-					end = start + int(kr.limit)
-					if end > lenKeys {
-						end = lenKeys
-					}
-				}
-				if start < lenKeys {
-					for i := start; i < end; i++ {
-						if byPrefix {
-							if len(keysDict[i]) < len(kr.fromKey) {
-								break
-							} else {
-								//compare with prefix
-								//fmt.Println("prefix", string(keysDict[i][:len(kr.fromKey)]), string(kr.fromKey))
-								if !bytes.Equal(keysDict[i][:len(kr.fromKey)], kr.fromKey) {
-									break
-								}
-							}
-						}
-						result = append(result, keysDict[i])
-					}
-				}
+			if len(group) == 1 {
+				err := s.set(wr.readKey, wr.writeVal, wr.expire)
+				wr.responseChan <- writeResponse{err}
 			} else {
-				//descending
-				start = lenKeys - 1
-				if kr.fromKey != nil {
-					if found == -1 {
-						start = -1
-					} else {
-						start = found - 1
-						if byPrefix {
-							//include
-							start = found
-						}
-					}
-				}
-
-				if kr.offset > 0 {
-					start -= int(kr.offset)
-				}
-				end = 0
-				if kr.limit > 0 {
-					end = start - int(kr.limit) + 1
-					if end < 0 {
-						end = 0
-					}
-				}
-				if start >= 0 {
-					for i := start; i >= end; i-- {
-						if byPrefix {
-							if len(keysDict[i]) < len(kr.fromKey) {
-								break
-							} else {
-								//compare with prefix
-								//fmt.Println("prefix", string(keysDict[i][:len(kr.fromKey)]), string(kr.fromKey))
-								if !bytes.Equal(keysDict[i][:len(kr.fromKey)], kr.fromKey) {
-									break
-								}
-							}
-						}
-						result = append(result, keysDict[i])
-					}
+				for i, err := range s.setGroup(group) {
+					group[i].responseChan <- writeResponse{err}
 				}
 			}
+			s.autoCompact()
+		case kr := <-keysRequests:
+			s.sweep()
+			result := findKeys(s.index, kr.fromKey, kr.limit, kr.offset, kr.asc)
 			kr.responseChan <- keysResponse{keys: result}
 			close(kr.responseChan)
 		case sr := <-setsRequests:
+			if s.version > 0 {
+				// pairs written as batch, so they are atomic
+				var ops []batchOp
+				for i := 1; i < len(sr.pairs); i += 2 {
+					if sr.pairs[i] == nil || sr.pairs[i-1] == nil {
+						break
+					}
+					ops = append(ops, batchOp{cmd: cmdSet, key: sr.pairs[i-1], val: sr.pairs[i]})
+				}
+				sr.responseChan <- setsResponse{s.writeBatch(ops)}
+				s.autoCompact()
+				continue
+			}
 			var err error
 			var seek, newSeek int64
+			s.valDirty = true
 			for i := range sr.pairs {
 				if i%2 != 0 {
 					// on odd - append val and store key
@@ -379,83 +851,101 @@ func run(parentCtx context.Context, fk *os.File, fv *os.File,
 					}
 					//key - sr.pairs[i-1]
 					//val - sr.pairs[i]
-					cmd := &Cmd{Size: uint32(len(sr.pairs[i]))}
-					seek, _, err = writeAtPos(fv, sr.pairs[i], int64(-1), false) //fv.WriteNoSync(sr.pairs[i])
-					cmd.Seek = uint32(seek)
+					cmd := &Cmd{Size: uint32(len(sr.pairs[i])), CRC: crc32.Checksum(sr.pairs[i], crcTable)}
+					seek, _, err = writeAtPos(s.fv, sr.pairs[i], int64(-1), false) //s.fv.WriteNoSync(sr.pairs[i])
+					cmd.Seek = uint64(seek)
 					if err != nil {
 						break
 					}
 
-					newSeek, err = writeKey(fk, 0, cmd.Seek, cmd.Size, sr.pairs[i-1], false, -1)
-					cmd.KeySeek = uint32(newSeek)
+					newSeek, err = writeKey(s.fk, cmd.record(s.version, sr.pairs[i-1]), false)
+					cmd.KeySeek = uint64(newSeek)
 					if err != nil {
 						break
 					}
-					keyStr := string(sr.pairs[i-1])
-					if _, exists := valDict[keyStr]; !exists {
-						//write new key at keys store
-						appendAsc(sr.pairs[i-1])
-						//keysDict = append(keysDict, sr.pairs[i-1])
-					}
-					valDict[keyStr] = cmd
-
+					s.grow(newSeek, seek, int(cmd.keySize(s.version, sr.pairs[i-1])), len(sr.pairs[i]))
+					s.mu.Lock()
+					s.setCmd(sr.pairs[i-1], cmd, len(sr.pairs[i]))
+					s.mu.Unlock()
 				}
 			}
 			if err == nil {
-				err = fk.Sync()
-				if err == nil {
-					err = fv.Sync()
-				}
+				err = s.written()
 			}
 
 			sr.responseChan <- setsResponse{err}
-		case gr := <-getsRequests:
-			var result [][]byte
-			result = make([][]byte, 0)
-			for _, key := range gr.keys {
-				if val, exists := valDict[string(key)]; exists {
-					//val, _ := fv.Read(int64(val.Size), int64(val.Seek))
-					b := make([]byte, val.Size)
-					fv.ReadAt(b, int64(val.Seek))
-					result = append(result, key)
-					result = append(result, b)
-				}
-			}
-			gr.responseChan <- getsResponse{result}
-		case hr := <-hasRequests:
-			_, exists := valDict[hr.key]
-			hr.responseChan <- hasResponse{exists: exists}
+			s.autoCompact()
 		case cgr := <-counterGetRequests:
 			var val uint64
 			switch cgr.key {
 			case NAME_COUNT_KEYS:
-				val = uint64(len(keysDict))
+				s.sweep()
+				val = uint64(s.index.len())
 			default:
-				val, _ = countersDict[cgr.key]
+				val, _ = s.countersDict[cgr.key]
 				val++
-				countersDict[cgr.key] = val
+				s.countersDict[cgr.key] = val
 			}
 
 			cgr.responseChan <- counterGetResponse{counter: val}
 		case csr := <-counterSetRequests:
 			if csr.store {
-				for k, v := range countersDict {
+				for k, v := range s.countersDict {
 					//store current counter
 					//fmt.Printf("%+v:%+v\n", k, v)
-					oldCmd, exists := valDict[k]
 					if v > 0 {
 
 						buf := make([]byte, 8)
 						binary.BigEndian.PutUint64(buf, v)
 
-						writeKeyVal(fk, fv, k, buf, exists, oldCmd)
+						s.set(k, buf, 0)
 					}
 				}
 			} else {
-				countersDict[csr.key] = csr.counter
+				s.countersDict[csr.key] = csr.counter
 			}
 
 			close(csr.responseChan)
+		case cr := <-compactRequests:
+			s.startCompact(cr)
+		case res := <-s.compactDone():
+			s.finishCompact(res)
+		case br := <-batchRequests:
+			err := s.writeBatch(br.ops)
+			br.responseChan <- writeResponse{err}
+			s.autoCompact()
+		case sr := <-snapshotRequests:
+			if sr.release != nil {
+				s.releaseView(sr.release)
+				sr.responseChan <- sr.release
+			} else {
+				v := s.snapshot()
+				v.done = ctx.Done()
+				sr.responseChan <- v
+			}
+		case er := <-expireRequests:
+			er.responseChan <- s.expire(er)
+		case <-sweeper.C:
+			s.sweep()
+			s.autoCheckpoint()
+		case res := <-s.checkpointing:
+			s.finishCheckpoint(res)
+		case c := <-checkpointRequests:
+			c <- s.checkpoint()
+		case c := <-syncRequests:
+			c <- s.sync()
+		case <-syncer:
+			s.sync()
+		case c := <-refreshRequests:
+			c <- s.refresh()
+		case <-refresher:
+			s.refresh()
+		case cr := <-changesRequests:
+			resp := changesResponse{seq: s.seq(s.keySize)}
+			if cr.limit >= 0 {
+				resp.changes, resp.err = s.changesSince(cr.seq, cr.limit)
+			}
+			cr.responseChan <- resp
 		}
 
 	}
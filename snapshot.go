@@ -0,0 +1,165 @@
+package gig
+
+import (
+	"crypto/cipher"
+	"os"
+	"sync"
+)
+
+// View is frozen read view of store created by Snapshot
+// Writes made after Snapshot are not visible in view
+// View must be released by Release, while it open values are not overwritten in place
+// and old values file is not closed after compaction
+type View struct {
+	mu       sync.RWMutex
+	fv       *os.File
+	version  uint8
+	aead     cipher.AEAD
+	at       int64
+	index    *node
+	released bool
+	// seq - sequence number of last change seen by view, see ChangesSince
+	seq uint64
+	// requests and done of store goroutine, view must not hold DB,
+	// so it will be finalized on Close
+	requests chan<- snapshotRequest
+	done     <-chan struct{}
+}
+
+type snapshotRequest struct {
+	// release is view to release, new view created if nil
+	release      *View
+	responseChan chan *View
+}
+
+// snapshot create view with root of index
+// Index and Cmd of key are never changed in place, so nothing copied
+func (s *store) snapshot() *View {
+	s.sweep()
+	v := &View{
+		fv:      s.fv,
+		version: s.version,
+		aead:    s.aead,
+		at:      now(),
+		index:   s.index,
+		seq:     s.seq(s.keySize),
+	}
+	if s.views == nil {
+		s.views = make(map[*os.File]int)
+	}
+	s.views[v.fv]++
+	return v
+}
+
+// releaseView forget view and close values file replaced by compaction
+func (s *store) releaseView(v *View) {
+	s.views[v.fv]--
+	if s.views[v.fv] > 0 {
+		return
+	}
+	delete(s.views, v.fv)
+	if v.fv != s.fv {
+		v.fv.Close()
+	}
+}
+
+// closeVal close values file replaced by compaction if no views use it
+func (s *store) closeVal(fv *os.File) {
+	if s.views[fv] == 0 {
+		fv.Close()
+	}
+}
+
+// pinned return true if values may be read by views, compaction or readers of other processes,
+// so they can not be overwritten
+func (s *store) pinned() bool {
+	return s.views[s.fv] > 0 || s.compacting != nil || s.shared()
+}
+
+// closeViews close values files of views, reads from them will fail
+func (s *store) closeViews() {
+	for fv := range s.views {
+		if fv != s.fv {
+			fv.Close()
+		}
+	}
+	s.views = nil
+}
+
+// Snapshot return frozen read view of store
+// View see keys and values as they was at the moment of Snapshot,
+// even if keys are changed, deleted, expired or store compacted later
+func Snapshot(file string) (*View, error) {
+	db, err := Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return db.snapshot()
+}
+
+// Release free view, after it all reads return ErrDbNotOpen
+func (v *View) Release() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.released {
+		return
+	}
+	c := make(chan *View)
+	select {
+	case v.requests <- snapshotRequest{release: v, responseChan: c}:
+		<-c
+	case <-v.done:
+		// store closed, files of view closed too
+	}
+	v.index, v.released = nil, true
+}
+
+// Get return value of key in view
+func (v *View) Get(key []byte) ([]byte, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.released {
+		return nil, ErrDbNotOpen
+	}
+	cmd := v.index.get(key)
+	if cmd == nil || cmd.expired(v.at) {
+		return nil, ErrKeyNotFound
+	}
+	return readVal(v.fv, v.version, v.aead, key, cmd)
+}
+
+// Gets return key/value pairs of existing keys in view
+func (v *View) Gets(keys [][]byte) [][]byte {
+	result := make([][]byte, 0)
+	for _, key := range keys {
+		if b, err := v.Get(key); err == nil {
+			result = append(result, key, b)
+		}
+	}
+	return result
+}
+
+// Has return true if key exists in view
+func (v *View) Has(key []byte) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	cmd := v.index.get(key)
+	return cmd != nil && !cmd.expired(v.at)
+}
+
+// Count return count of keys in view
+func (v *View) Count() uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return uint64(v.index.len())
+}
+
+// Keys return keys of view like Keys
+func (v *View) Keys(from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.released {
+		return nil, ErrDbNotOpen
+	}
+	return findKeys(v.index, from, limit, offset, asc), nil
+}
@@ -0,0 +1,76 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	f := "tests/TestSnapshot.db"
+	DeleteFile(f)
+	defer CloseAll()
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("%04d", i))
+		ch(Set(f, k, bytes.Repeat(k, 2)), t)
+	}
+	v, err := Snapshot(f)
+	ch(err, t)
+	defer v.Release()
+
+	// smaller value may be written in place, it must not change view
+	ch(Set(f, []byte("0000"), []byte("new")), t)
+	Delete(f, []byte("0001"))
+	ch(Set(f, []byte("0010"), []byte("new")), t)
+	if _, err = Compact(f); err != nil {
+		t.Error(err)
+	}
+
+	if b, err := v.Get([]byte("0000")); err != nil || string(b) != "00000000" {
+		t.Error("view changed by write", string(b), err)
+	}
+	if b, err := v.Get([]byte("0001")); err != nil || string(b) != "00010001" {
+		t.Error("view changed by delete", string(b), err)
+	}
+	if v.Has([]byte("0010")) {
+		t.Error("new key in view")
+	}
+	if v.Count() != 10 {
+		t.Error("wrong count", v.Count())
+	}
+	keys, err := v.Keys([]byte("0008"), 0, 0, true)
+	if err != nil || len(keys) != 1 || string(keys[0]) != "0009" {
+		t.Error("wrong keys", keys, err)
+	}
+	pairs := v.Gets([][]byte{[]byte("0001"), []byte("0010")})
+	if len(pairs) != 2 || string(pairs[1]) != "00010001" {
+		t.Error("wrong pairs", pairs)
+	}
+
+	if b, err := Get(f, []byte("0000")); err != nil || string(b) != "new" {
+		t.Error("write not visible", string(b), err)
+	}
+	v.Release()
+	if _, err := v.Get([]byte("0000")); err != ErrDbNotOpen {
+		t.Error("read from released view", err)
+	}
+	if b, err := Get(f, []byte("0002")); err != nil || string(b) != "00020002" {
+		t.Error("value lost after release", string(b), err)
+	}
+}
+
+func TestSnapshotClose(t *testing.T) {
+	f := "tests/TestSnapshotClose.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(Set(f, []byte("key"), []byte("val")), t)
+	v, err := Snapshot(f)
+	ch(err, t)
+	Close(f)
+	// view must not keep store opened
+	ch(Set(f, []byte("key"), []byte("new")), t)
+	v.Release()
+	if b, err := Get(f, []byte("key")); err != nil || string(b) != "new" {
+		t.Error("not equal", string(b), err)
+	}
+}
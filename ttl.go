@@ -0,0 +1,111 @@
+package gig
+
+import (
+	"container/heap"
+	"time"
+)
+
+type expireResponse struct {
+	expire int64
+	err    error
+}
+
+// expireRequest change expiration of key if set is true, else return it
+type expireRequest struct {
+	key          string
+	expire       int64
+	set          bool
+	responseChan chan expireResponse
+}
+
+// expireItem - key and its expiration time in expireHeap
+type expireItem struct {
+	expire int64
+	key    string
+}
+
+// expireHeap - min heap of expiration times
+// Items are not removed when key changed, they checked on pop
+type expireHeap []expireItem
+
+func (h expireHeap) Len() int            { return len(h) }
+func (h expireHeap) Less(i, j int) bool  { return h[i].expire < h[j].expire }
+func (h expireHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expireHeap) Push(x interface{}) { *h = append(*h, x.(expireItem)) }
+func (h *expireHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// now return current unix time in milliseconds
+func now() int64 {
+	return time.Now().UnixMilli()
+}
+
+// expired return true if key of command expired at time t
+func (cmd *Cmd) expired(t int64) bool {
+	return cmd.Expire != 0 && cmd.Expire <= t
+}
+
+// hasExpired return true if expired keys may be in index
+func (s *store) hasExpired() bool {
+	return len(s.expires) > 0 && s.expires[0].expire <= now()
+}
+
+// sweep delete expired keys from index and write delete records for them
+// Records written without sync, keys file synced once at end
+func (s *store) sweep() {
+	t := now()
+	var swept [][]byte
+	s.mu.Lock()
+	for len(s.expires) > 0 && s.expires[0].expire <= t {
+		item := heap.Pop(&s.expires).(expireItem)
+		cmd, exists := s.valDict[item.key]
+		if !exists || cmd.Expire != item.expire {
+			// key deleted or expiration changed
+			continue
+		}
+		key := []byte(item.key)
+		s.delCmd(key)
+		swept = append(swept, key)
+	}
+	s.mu.Unlock()
+	if s.readOnly {
+		// keys deleted from file by writer process
+		return
+	}
+	for _, key := range swept {
+		s.writeDelete(key, false)
+	}
+	if len(swept) > 0 {
+		s.written()
+		s.autoCompact()
+	}
+}
+
+// expire set or return expiration time of key
+// Expiration time written as new key record with same value
+func (s *store) expire(er expireRequest) expireResponse {
+	cmd, exists := s.valDict[er.key]
+	if !exists || cmd.expired(now()) {
+		return expireResponse{err: ErrKeyNotFound}
+	}
+	if !er.set || cmd.Expire == er.expire {
+		return expireResponse{expire: cmd.Expire}
+	}
+	newCmd := *cmd
+	newCmd.Expire = er.expire
+	key := []byte(er.key)
+	seek, err := writeKey(s.fk, newCmd.record(s.version, key), false)
+	if err != nil {
+		return expireResponse{err: err}
+	}
+	newCmd.KeySeek = uint64(seek)
+	s.grow(seek, 0, int(newCmd.keySize(s.version, key)), 0)
+	s.mu.Lock()
+	s.setCmd(key, &newCmd, s.valLen(key, &newCmd))
+	s.mu.Unlock()
+	return expireResponse{expire: newCmd.Expire, err: s.written()}
+}
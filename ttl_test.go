@@ -0,0 +1,100 @@
+package gig
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	f := "tests/TestTTL.db"
+	DeleteFile(f)
+	defer CloseAll()
+	ch(SetWithTTL(f, []byte("temp"), []byte("val"), 50*time.Millisecond), t)
+	ch(Set(f, []byte("keep"), []byte("val")), t)
+	ch(Set(f, []byte("later"), []byte("val")), t)
+	ch(Expire(f, []byte("later"), time.Hour), t)
+
+	if ttl, err := TTL(f, []byte("temp")); err != nil || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Error("wrong ttl", ttl, err)
+	}
+	if ttl, err := TTL(f, []byte("keep")); err != nil || ttl != 0 {
+		t.Error("ttl of persistent key", ttl, err)
+	}
+	if err := Expire(f, []byte("none"), time.Hour); err != ErrKeyNotFound {
+		t.Error("expire of missing key", err)
+	}
+	if cnt, _ := Count(f); cnt != 3 {
+		t.Error("count before expiration", cnt)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := Get(f, []byte("temp")); err != ErrKeyNotFound {
+		t.Error("expired key found", err)
+	}
+	if has, _ := Has(f, []byte("temp")); has {
+		t.Error("expired key exists")
+	}
+	if _, err := TTL(f, []byte("temp")); err != ErrKeyNotFound {
+		t.Error("ttl of expired key", err)
+	}
+	if cnt, _ := Count(f); cnt != 2 {
+		t.Error("count after expiration", cnt)
+	}
+	keys, _ := Keys(f, nil, 0, 0, true)
+	if len(keys) != 2 || string(keys[0]) != "keep" || string(keys[1]) != "later" {
+		t.Error("keys after expiration", keys)
+	}
+
+	// expiration survive restart and can be removed
+	Close(f)
+	if ttl, err := TTL(f, []byte("later")); err != nil || ttl <= 59*time.Minute {
+		t.Error("ttl after reopen", ttl, err)
+	}
+	ch(Expire(f, []byte("later"), 0), t)
+	Close(f)
+	if ttl, err := TTL(f, []byte("later")); err != nil || ttl != 0 {
+		t.Error("expiration not removed", ttl, err)
+	}
+	if _, err := Get(f, []byte("temp")); err != ErrKeyNotFound {
+		t.Error("expired key found after reopen", err)
+	}
+}
+
+func TestTTLSweep(t *testing.T) {
+	f := "tests/TestTTLSweep.db"
+	DeleteFile(f)
+	defer CloseAll()
+	interval := SweepInterval
+	SweepInterval = 10 * time.Millisecond
+	defer func() {
+		SweepInterval = interval
+	}()
+	ch(SetWithTTL(f, []byte("temp"), []byte("val"), 20*time.Millisecond), t)
+	info, err := os.Stat(f + KEY_FILE_EXT)
+	ch(err, t)
+	size := info.Size()
+
+	// sweeper write delete record without any request
+	deleted := size + keyRecordSize(FORMAT_VERSION, cmdDelete, []byte("temp"))
+	for i := 0; i < 100 && size < deleted; i++ {
+		time.Sleep(10 * time.Millisecond)
+		info, err = os.Stat(f + KEY_FILE_EXT)
+		ch(err, t)
+		size = info.Size()
+	}
+	if size != deleted {
+		t.Error("expired key not swept", size, deleted)
+	}
+}
+
+func TestTTLFormatV0(t *testing.T) {
+	f := "tests/TestTTLFormatV0.db"
+	createV0(t, f, 1)
+	defer CloseAll()
+	if err := SetWithTTL(f, []byte("key"), []byte("val"), time.Hour); err != ErrNeedUpgrade {
+		t.Error("expiration in version 0", err)
+	}
+	ch(Upgrade(f), t)
+	ch(SetWithTTL(f, []byte("key"), []byte("val"), time.Hour), t)
+}
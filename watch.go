@@ -0,0 +1,137 @@
+package gig
+
+import (
+	"bytes"
+	"time"
+)
+
+// EventOp - kind of Event
+type EventOp uint8
+
+const (
+	// EVENT_SET - key set, its value or expiration time changed
+	EVENT_SET EventOp = iota
+	// EVENT_DELETE - key deleted or expired
+	EVENT_DELETE
+	// EVENT_OVERFLOW - events was dropped, because watcher did not read them in time
+	EVENT_OVERFLOW
+	// EVENT_DELETE_RANGE - keys of range deleted, see Change
+	EVENT_DELETE_RANGE
+)
+
+// Event - change of key sent to watcher, see Watch
+type Event struct {
+	Op  EventOp
+	Key []byte
+	// Size - length of new value, not of compressed or sealed bytes stored in file
+	Size int
+	// Time - time of change
+	Time time.Time
+	// Dropped - count of events dropped before EVENT_OVERFLOW
+	Dropped int
+}
+
+// WatchBuffer - count of events buffered for every watcher
+var WatchBuffer = 256
+
+// watcher receive events of keys with prefix
+type watcher struct {
+	prefix  []byte
+	events  chan Event
+	dropped int
+	closed  bool
+}
+
+// send send event without waiting, if buffer is full event is dropped
+// Dropped events are reported by EVENT_OVERFLOW before next event
+func (w *watcher) send(e Event) {
+	if w.dropped > 0 {
+		select {
+		case w.events <- Event{Op: EVENT_OVERFLOW, Time: e.Time, Dropped: w.dropped}:
+			w.dropped = 0
+		default:
+			w.dropped++
+			return
+		}
+	}
+	select {
+	case w.events <- e:
+	default:
+		w.dropped++
+	}
+}
+
+// changed remember change of key for watchers, it is sent by notify
+func (s *store) changed(op EventOp, key []byte, size int) {
+	if s.loading || s.watched.Load() == 0 {
+		return
+	}
+	// key may be owned by caller of write
+	key = append([]byte{}, key...)
+	s.changes = append(s.changes, Event{Op: op, Key: key, Size: size, Time: time.Now()})
+}
+
+// valLen return length of value of key for watchers when value is not at hand
+// Compressed or sealed value read only if store is watched, 0 if it can not be read
+func (s *store) valLen(key []byte, cmd *Cmd) int {
+	if s.loading || s.watched.Load() == 0 || (cmd.Codec == CODEC_NONE && !cmd.Sealed) {
+		return int(cmd.Size)
+	}
+	val, err := readVal(s.fv, s.version, s.aead, key, cmd)
+	if err != nil {
+		return 0
+	}
+	return len(val)
+}
+
+// notify send changes made by last request to watchers
+func (s *store) notify() {
+	if len(s.changes) == 0 {
+		return
+	}
+	s.watchMu.Lock()
+	for _, e := range s.changes {
+		for w := range s.watchers {
+			if bytes.HasPrefix(e.Key, w.prefix) {
+				w.send(e)
+			}
+		}
+	}
+	s.watchMu.Unlock()
+	s.changes = s.changes[:0]
+}
+
+// watch add watcher of keys with prefix and return its events and cancel function
+func (s *store) watch(prefix []byte) (<-chan Event, func()) {
+	w := &watcher{prefix: append([]byte{}, prefix...), events: make(chan Event, WatchBuffer)}
+	s.watchMu.Lock()
+	if s.watchers == nil {
+		s.watchers = make(map[*watcher]struct{})
+	}
+	s.watchers[w] = struct{}{}
+	s.watchMu.Unlock()
+	s.watched.Add(1)
+	return w.events, func() {
+		s.watchMu.Lock()
+		defer s.watchMu.Unlock()
+		if w.closed {
+			return
+		}
+		delete(s.watchers, w)
+		s.watched.Add(-1)
+		w.closed = true
+		close(w.events)
+	}
+}
+
+// closeWatchers close events of all watchers on Close
+func (s *store) closeWatchers() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for w := range s.watchers {
+		w.closed = true
+		close(w.events)
+	}
+	s.watchers = nil
+	s.watched.Store(0)
+}
@@ -0,0 +1,101 @@
+package gig
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	f := "tests/TestWatch.db"
+	DeleteFile(f)
+	defer CloseAll()
+	events, cancel, err := Watch(f, []byte("loc:"))
+	ch(err, t)
+	all, cancelAll, err := Watch(f, nil)
+	ch(err, t)
+	defer cancelAll()
+	start := time.Now()
+	ch(Set(f, []byte("loc:1"), []byte("123")), t)
+	ch(Set(f, []byte("other"), []byte("1")), t)
+	Delete(f, []byte("loc:1"))
+	batch := &WriteBatch{}
+	batch.Put([]byte("loc:2"), []byte("12"))
+	batch.Put([]byte("loc:3"), []byte("1"))
+	ch(Write(f, batch), t)
+	_, err = DeletePrefix(f, []byte("loc:"))
+	ch(err, t)
+
+	for _, want := range []Event{{Op: EVENT_SET, Key: []byte("loc:1"), Size: 3}, {Op: EVENT_DELETE, Key: []byte("loc:1")},
+		{Op: EVENT_SET, Key: []byte("loc:2"), Size: 2}, {Op: EVENT_SET, Key: []byte("loc:3"), Size: 1},
+		{Op: EVENT_DELETE, Key: []byte("loc:2")}, {Op: EVENT_DELETE, Key: []byte("loc:3")}} {
+		select {
+		case e := <-events:
+			if e.Op != want.Op || string(e.Key) != string(want.Key) || e.Size != want.Size || e.Time.Before(start) {
+				t.Error("wrong event", e, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no event", want)
+		}
+	}
+	if len(all) != 7 {
+		t.Error("wrong count of events", len(all))
+	}
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("events not closed")
+	}
+
+	// slow watcher
+	size := WatchBuffer
+	WatchBuffer = 2
+	slow, cancelSlow, err := Watch(f, nil)
+	WatchBuffer = size
+	ch(err, t)
+	defer cancelSlow()
+	for _, k := range []string{"1", "2", "3", "4", "5"} {
+		ch(Set(f, []byte(k), []byte(k)), t)
+	}
+	for _, k := range []string{"1", "2"} {
+		if e := <-slow; string(e.Key) != k {
+			t.Error("wrong event", e)
+		}
+	}
+	ch(Set(f, []byte("6"), []byte("6")), t)
+	if e := <-slow; e.Op != EVENT_OVERFLOW || e.Dropped != 3 {
+		t.Error("no overflow", e)
+	}
+	if e := <-slow; string(e.Key) != "6" {
+		t.Error("wrong event", e)
+	}
+
+	// events closed on Close
+	Close(f)
+	if _, ok := <-slow; ok {
+		t.Error("events not closed")
+	}
+}
+
+func TestWatchSize(t *testing.T) {
+	f := "tests/TestWatchSize.db"
+	DeleteFile(f)
+	defer CloseAll()
+	_, err := OpenWithOptions(f, &Options{Key: bytes.Repeat([]byte("k"), 32), Compression: CODEC_LZ, CompressMinSize: 1})
+	ch(err, t)
+	events, cancel, err := Watch(f, nil)
+	ch(err, t)
+	defer cancel()
+	val := bytes.Repeat([]byte("value "), 100)
+	ch(Set(f, []byte("a"), val), t)
+	batch := &WriteBatch{}
+	batch.Put([]byte("b"), val)
+	ch(Write(f, batch), t)
+	ch(Expire(f, []byte("a"), time.Hour), t)
+	// size of compressed and sealed value is length of value
+	for _, k := range []string{"a", "b", "a"} {
+		if e := <-events; string(e.Key) != k || e.Size != len(val) {
+			t.Error("wrong size", string(e.Key), e.Size)
+		}
+	}
+}